@@ -4,18 +4,120 @@ import (
 	"context"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// insertKeyedTrackPoints relies on track_points_point_id_idx to skip points
+// that were already stored by an earlier (retried) batch.
+const insertKeyedTrackPoints = `
+INSERT INTO track_points (
+        time,
+        container_id,
+        lat,
+        lon,
+        speed,
+        point_id,
+        heading,
+        altitude,
+        accuracy,
+        battery,
+        temperature,
+        humidity,
+        door_open
+    )
+SELECT *
+FROM unnest(
+        $1::timestamptz [],
+        $2::text [],
+        $3::double precision [],
+        $4::double precision [],
+        $5::double precision [],
+        $6::text [],
+        $7::double precision [],
+        $8::double precision [],
+        $9::double precision [],
+        $10::double precision [],
+        $11::double precision [],
+        $12::double precision [],
+        $13::boolean []
+    ) ON CONFLICT DO NOTHING
+`
+
 // sqlc can't handle PostGIS `GEOGRAPHY` type well, so we need to manual COPY for maximum performance
+//
+// COPY can't skip conflicting rows, so points carrying a device-supplied
+// PointID go through INSERT ... ON CONFLICT DO NOTHING instead. They are
+// written first: if the COPY then fails, retrying the whole batch is safe.
 func (q *Queries) BulkInsertTrackPoints(ctx context.Context, points []TrackPoint) error {
+	var keyed, plain []TrackPoint
+	for _, p := range points {
+		if p.PointID.Valid {
+			keyed = append(keyed, p)
+		} else {
+			plain = append(plain, p)
+		}
+	}
+
+	if len(keyed) > 0 {
+		if err := q.insertKeyedTrackPoints(ctx, keyed); err != nil {
+			return err
+		}
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+
 	_, err := q.db.CopyFrom(
 		ctx,
 		pgx.Identifier{"track_points"},
-		[]string{"time", "container_id", "lat", "lon", "speed"},
-		pgx.CopyFromSlice(len(points), func(i int) ([]any, error) {
-			p := points[i]
-			return []any{p.Time, p.ContainerID, p.Lat, p.Lon, p.Speed}, nil
+		[]string{
+			"time", "container_id", "lat", "lon", "speed",
+			"heading", "altitude", "accuracy", "battery", "temperature", "humidity", "door_open",
+		},
+		pgx.CopyFromSlice(len(plain), func(i int) ([]any, error) {
+			p := plain[i]
+			return []any{
+				p.Time, p.ContainerID, p.Lat, p.Lon, p.Speed,
+				p.Heading, p.Altitude, p.Accuracy, p.Battery, p.Temperature, p.Humidity, p.DoorOpen,
+			}, nil
 		}),
 	)
 	return err
 }
+
+func (q *Queries) insertKeyedTrackPoints(ctx context.Context, points []TrackPoint) error {
+	times := make([]pgtype.Timestamptz, len(points))
+	containerIDs := make([]string, len(points))
+	lats := make([]float64, len(points))
+	lons := make([]float64, len(points))
+	speeds := make([]pgtype.Float8, len(points))
+	pointIDs := make([]pgtype.Text, len(points))
+	headings := make([]pgtype.Float8, len(points))
+	altitudes := make([]pgtype.Float8, len(points))
+	accuracies := make([]pgtype.Float8, len(points))
+	batteries := make([]pgtype.Float8, len(points))
+	temperatures := make([]pgtype.Float8, len(points))
+	humidities := make([]pgtype.Float8, len(points))
+	doorOpen := make([]pgtype.Bool, len(points))
+	for i, p := range points {
+		times[i] = p.Time
+		containerIDs[i] = p.ContainerID
+		lats[i] = p.Lat
+		lons[i] = p.Lon
+		speeds[i] = p.Speed
+		pointIDs[i] = p.PointID
+		headings[i] = p.Heading
+		altitudes[i] = p.Altitude
+		accuracies[i] = p.Accuracy
+		batteries[i] = p.Battery
+		temperatures[i] = p.Temperature
+		humidities[i] = p.Humidity
+		doorOpen[i] = p.DoorOpen
+	}
+
+	_, err := q.db.Exec(ctx, insertKeyedTrackPoints,
+		times, containerIDs, lats, lons, speeds, pointIDs,
+		headings, altitudes, accuracies, batteries, temperatures, humidities, doorOpen,
+	)
+	return err
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS track_points_point_id_idx;
+ALTER TABLE track_points DROP COLUMN IF EXISTS point_id;
@@ -0,0 +1,7 @@
+-- Device-supplied point ID for idempotent ingestion (NULL for legacy producers)
+ALTER TABLE track_points
+ADD COLUMN IF NOT EXISTS point_id TEXT;
+-- Unique indexes on a hypertable must include the partition column.
+-- A retried upload repeats the same reading, so time matches as well.
+-- NULLs are distinct, so points without an ID are never rejected.
+CREATE UNIQUE INDEX IF NOT EXISTS track_points_point_id_idx ON track_points (container_id, point_id, time);
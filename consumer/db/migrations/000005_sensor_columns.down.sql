@@ -0,0 +1,7 @@
+ALTER TABLE track_points DROP COLUMN IF EXISTS heading,
+    DROP COLUMN IF EXISTS altitude,
+    DROP COLUMN IF EXISTS accuracy,
+    DROP COLUMN IF EXISTS battery,
+    DROP COLUMN IF EXISTS temperature,
+    DROP COLUMN IF EXISTS humidity,
+    DROP COLUMN IF EXISTS door_open;
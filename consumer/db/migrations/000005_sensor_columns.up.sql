@@ -0,0 +1,9 @@
+-- Optional device sensor readings (NULL when the device doesn't report them)
+ALTER TABLE track_points
+ADD COLUMN IF NOT EXISTS heading DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS altitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS battery DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS humidity DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS door_open BOOLEAN;
@@ -0,0 +1 @@
+DROP MATERIALIZED VIEW IF EXISTS track_points_hourly;
@@ -0,0 +1,20 @@
+-- Hourly summary per container, for routes at coarse zoom levels.
+-- lat/lon is the last fix of the hour, so the summary stays on the route.
+-- Not materialized_only: hours not refreshed yet are aggregated on read.
+-- A continuous aggregate can't be created in a transaction block, so this
+-- file must stay a single statement.
+CREATE MATERIALIZED VIEW IF NOT EXISTS track_points_hourly WITH (
+    timescaledb.continuous,
+    timescaledb.materialized_only = false
+) AS
+SELECT time_bucket('1 hour', time) AS bucket,
+    container_id,
+    COUNT(*) AS point_count,
+    AVG(speed) AS avg_speed,
+    MAX(speed) AS max_speed,
+    last(lat, time) AS lat,
+    last(lon, time) AS lon,
+    MAX(time) AS last_time
+FROM track_points
+GROUP BY bucket,
+    container_id WITH DATA;
@@ -0,0 +1 @@
+SELECT remove_continuous_aggregate_policy('track_points_hourly', if_exists => true);
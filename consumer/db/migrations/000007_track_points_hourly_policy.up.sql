@@ -0,0 +1,8 @@
+-- Refresh every 30 minutes, up to an hour ago
+SELECT add_continuous_aggregate_policy(
+        'track_points_hourly',
+        start_offset => INTERVAL '3 hours',
+        end_offset => INTERVAL '1 hour',
+        schedule_interval => INTERVAL '30 minutes',
+        if_not_exists => TRUE
+    );
@@ -22,6 +22,14 @@ type TrackPoint struct {
 	Lat         float64
 	Lon         float64
 	Speed       pgtype.Float8
+	PointID     pgtype.Text
+	Heading     pgtype.Float8
+	Altitude    pgtype.Float8
+	Accuracy    pgtype.Float8
+	Battery     pgtype.Float8
+	Temperature pgtype.Float8
+	Humidity    pgtype.Float8
+	DoorOpen    pgtype.Bool
 }
 
 type TrackPointsHourly struct {
@@ -30,5 +38,7 @@ type TrackPointsHourly struct {
 	PointCount  int64
 	AvgSpeed    float64
 	MaxSpeed    interface{}
-	RouteLine   interface{}
+	Lat         interface{}
+	Lon         interface{}
+	LastTime    interface{}
 }
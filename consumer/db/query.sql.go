@@ -19,6 +19,30 @@ type CreateTrackPointsParams struct {
 	Speed       pgtype.Float8
 }
 
+const getContainer = `-- name: GetContainer :one
+SELECT id,
+    container_id,
+    owner,
+    container_type,
+    created_at
+FROM containers
+WHERE container_id = $1
+`
+
+// Container metadata
+func (q *Queries) GetContainer(ctx context.Context, containerID string) (Container, error) {
+	row := q.db.QueryRow(ctx, getContainer, containerID)
+	var i Container
+	err := row.Scan(
+		&i.ID,
+		&i.ContainerID,
+		&i.Owner,
+		&i.ContainerType,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const getContainerRoute = `-- name: GetContainerRoute :many
 SELECT time,
     lat,
@@ -26,14 +50,21 @@ SELECT time,
     speed
 FROM track_points
 WHERE container_id = $1
-    AND time BETWEEN $2 AND $3
-ORDER BY time
+    AND time >= $2
+    AND time < $3
+ORDER BY time,
+    lat,
+    lon,
+    speed
+LIMIT $4 OFFSET $5
 `
 
 type GetContainerRouteParams struct {
 	ContainerID string
-	Time        pgtype.Timestamptz
-	Time_2      pgtype.Timestamptz
+	FromTime    pgtype.Timestamptz
+	ToTime      pgtype.Timestamptz
+	MaxRows     int32
+	SkipRows    int32
 }
 
 type GetContainerRouteRow struct {
@@ -43,9 +74,17 @@ type GetContainerRouteRow struct {
 	Speed pgtype.Float8
 }
 
-// Container route for time range (draw polyline)
+// Container route for time range [from, to) (draw polyline)
+// Points sharing a timestamp are ordered by the selected columns, so a page
+// can resume at from_time after skip_rows points without losing any
 func (q *Queries) GetContainerRoute(ctx context.Context, arg GetContainerRouteParams) ([]GetContainerRouteRow, error) {
-	rows, err := q.db.Query(ctx, getContainerRoute, arg.ContainerID, arg.Time, arg.Time_2)
+	rows, err := q.db.Query(ctx, getContainerRoute,
+		arg.ContainerID,
+		arg.FromTime,
+		arg.ToTime,
+		arg.MaxRows,
+		arg.SkipRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +108,112 @@ func (q *Queries) GetContainerRoute(ctx context.Context, arg GetContainerRoutePa
 	return items, nil
 }
 
+const getContainerRouteHourly = `-- name: GetContainerRouteHourly :many
+SELECT last_time::timestamptz AS time,
+    lat::double precision AS lat,
+    lon::double precision AS lon,
+    avg_speed
+FROM track_points_hourly
+WHERE container_id = $1
+    AND bucket >= $2::timestamptz
+    AND bucket < $3::timestamptz
+ORDER BY bucket
+`
+
+type GetContainerRouteHourlyParams struct {
+	ContainerID string
+	FromTime    pgtype.Timestamptz
+	ToTime      pgtype.Timestamptz
+}
+
+type GetContainerRouteHourlyRow struct {
+	Time     pgtype.Timestamptz
+	Lat      float64
+	Lon      float64
+	AvgSpeed pgtype.Float8
+}
+
+// Container route from the hourly summary, one point per hour (coarse zoom)
+func (q *Queries) GetContainerRouteHourly(ctx context.Context, arg GetContainerRouteHourlyParams) ([]GetContainerRouteHourlyRow, error) {
+	rows, err := q.db.Query(ctx, getContainerRouteHourly, arg.ContainerID, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetContainerRouteHourlyRow
+	for rows.Next() {
+		var i GetContainerRouteHourlyRow
+		if err := rows.Scan(
+			&i.Time,
+			&i.Lat,
+			&i.Lon,
+			&i.AvgSpeed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestPosition = `-- name: GetLatestPosition :one
+SELECT time,
+    lat,
+    lon,
+    speed,
+    point_id,
+    heading,
+    altitude,
+    accuracy,
+    battery,
+    temperature,
+    humidity,
+    door_open
+FROM track_points
+WHERE container_id = $1
+ORDER BY time DESC
+LIMIT 1
+`
+
+type GetLatestPositionRow struct {
+	Time        pgtype.Timestamptz
+	Lat         float64
+	Lon         float64
+	Speed       pgtype.Float8
+	PointID     pgtype.Text
+	Heading     pgtype.Float8
+	Altitude    pgtype.Float8
+	Accuracy    pgtype.Float8
+	Battery     pgtype.Float8
+	Temperature pgtype.Float8
+	Humidity    pgtype.Float8
+	DoorOpen    pgtype.Bool
+}
+
+// Latest position of one container, however old, with its sensor readings
+func (q *Queries) GetLatestPosition(ctx context.Context, containerID string) (GetLatestPositionRow, error) {
+	row := q.db.QueryRow(ctx, getLatestPosition, containerID)
+	var i GetLatestPositionRow
+	err := row.Scan(
+		&i.Time,
+		&i.Lat,
+		&i.Lon,
+		&i.Speed,
+		&i.PointID,
+		&i.Heading,
+		&i.Altitude,
+		&i.Accuracy,
+		&i.Battery,
+		&i.Temperature,
+		&i.Humidity,
+		&i.DoorOpen,
+	)
+	return i, err
+}
+
 const getLatestPositions = `-- name: GetLatestPositions :many
 SELECT DISTINCT ON (container_id) container_id,
     time,
@@ -76,11 +221,19 @@ SELECT DISTINCT ON (container_id) container_id,
     lon,
     speed
 FROM track_points
-WHERE time > NOW() - INTERVAL '1 hour'
+WHERE time > $1
+    AND container_id > $2
 ORDER BY container_id,
     time DESC
+LIMIT $3
 `
 
+type GetLatestPositionsParams struct {
+	Since   pgtype.Timestamptz
+	After   string
+	MaxRows int32
+}
+
 type GetLatestPositionsRow struct {
 	ContainerID string
 	Time        pgtype.Timestamptz
@@ -89,9 +242,9 @@ type GetLatestPositionsRow struct {
 	Speed       pgtype.Float8
 }
 
-// Latest position per container (map markers)
-func (q *Queries) GetLatestPositions(ctx context.Context) ([]GetLatestPositionsRow, error) {
-	rows, err := q.db.Query(ctx, getLatestPositions)
+// Latest position per container (map markers), a page of containers after @after
+func (q *Queries) GetLatestPositions(ctx context.Context, arg GetLatestPositionsParams) ([]GetLatestPositionsRow, error) {
+	rows, err := q.db.Query(ctx, getLatestPositions, arg.Since, arg.After, arg.MaxRows)
 	if err != nil {
 		return nil, err
 	}
@@ -115,3 +268,15 @@ func (q *Queries) GetLatestPositions(ctx context.Context) ([]GetLatestPositionsR
 	}
 	return items, nil
 }
+
+const registerContainers = `-- name: RegisterContainers :exec
+INSERT INTO containers (container_id)
+SELECT unnest($1::text [])
+ON CONFLICT (container_id) DO NOTHING
+`
+
+// Register containers on first sighting; existing rows keep their metadata
+func (q *Queries) RegisterContainers(ctx context.Context, containerIds []string) error {
+	_, err := q.db.Exec(ctx, registerContainers, containerIds)
+	return err
+}
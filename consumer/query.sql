@@ -2,7 +2,7 @@
 -- name: CreateTrackPoints :copyfrom
 INSERT INTO track_points (time, container_id, lat, lon, speed)
 VALUES ($1, $2, $3, $4, $5);
--- Latest position per container (map markers)
+-- Latest position per container (map markers), a page of containers after @after
 -- name: GetLatestPositions :many
 SELECT DISTINCT ON (container_id) container_id,
     time,
@@ -10,16 +10,68 @@ SELECT DISTINCT ON (container_id) container_id,
     lon,
     speed
 FROM track_points
-WHERE time > NOW() - INTERVAL '1 hour'
+WHERE time > @since
+    AND container_id > @after
 ORDER BY container_id,
-    time DESC;
--- Container route for time range (draw polyline)
+    time DESC
+LIMIT @max_rows;
+-- Latest position of one container, however old, with its sensor readings
+-- name: GetLatestPosition :one
+SELECT time,
+    lat,
+    lon,
+    speed,
+    point_id,
+    heading,
+    altitude,
+    accuracy,
+    battery,
+    temperature,
+    humidity,
+    door_open
+FROM track_points
+WHERE container_id = $1
+ORDER BY time DESC
+LIMIT 1;
+-- Container route for time range [from, to) (draw polyline)
+-- Points sharing a timestamp are ordered by the selected columns, so a page
+-- can resume at from_time after skip_rows points without losing any
 -- name: GetContainerRoute :many
 SELECT time,
     lat,
     lon,
     speed
 FROM track_points
-WHERE container_id = $1
-    AND time BETWEEN $2 AND $3
-ORDER BY time;
\ No newline at end of file
+WHERE container_id = @container_id
+    AND time >= @from_time
+    AND time < @to_time
+ORDER BY time,
+    lat,
+    lon,
+    speed
+LIMIT @max_rows OFFSET @skip_rows;
+-- Container route from the hourly summary, one point per hour (coarse zoom)
+-- name: GetContainerRouteHourly :many
+SELECT last_time::timestamptz AS time,
+    lat::double precision AS lat,
+    lon::double precision AS lon,
+    avg_speed
+FROM track_points_hourly
+WHERE container_id = @container_id
+    AND bucket >= @from_time::timestamptz
+    AND bucket < @to_time::timestamptz
+ORDER BY bucket;
+-- Container metadata
+-- name: GetContainer :one
+SELECT id,
+    container_id,
+    owner,
+    container_type,
+    created_at
+FROM containers
+WHERE container_id = $1;
+-- Register containers on first sighting; existing rows keep their metadata
+-- name: RegisterContainers :exec
+INSERT INTO containers (container_id)
+SELECT unnest(@container_ids::text [])
+ON CONFLICT (container_id) DO NOTHING;
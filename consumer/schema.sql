@@ -23,8 +23,18 @@ CREATE TABLE track_points (
     container_id TEXT NOT NULL,
     lat DOUBLE PRECISION NOT NULL,
     lon DOUBLE PRECISION NOT NULL,
-    -- km/h, nullable
+    -- m/s, nullable
     speed DOUBLE PRECISION,
+    -- Device-supplied point ID for idempotent ingestion, nullable
+    point_id TEXT,
+    -- Optional device sensor readings, nullable
+    heading DOUBLE PRECISION,
+    altitude DOUBLE PRECISION,
+    accuracy DOUBLE PRECISION,
+    battery DOUBLE PRECISION,
+    temperature DOUBLE PRECISION,
+    humidity DOUBLE PRECISION,
+    door_open BOOLEAN,
     -- No separate PK - TimescaleDB uses (time, container_id) as natural key
     CONSTRAINT valid_speed CHECK (
         speed IS NULL
@@ -40,6 +50,8 @@ SELECT create_hypertable(
 -- Composite index: container + time range queries (most common pattern)
 -- Supabase: query-composite-indexes - equality column first, range column last
 CREATE INDEX track_points_container_time_idx ON track_points (container_id, time DESC);
+-- Dedup key for retried uploads (must include the partition column)
+CREATE UNIQUE INDEX track_points_point_id_idx ON track_points (container_id, point_id, time);
 -- Spatial index for "find containers near location" queries
 CREATE INDEX track_points_location_idx ON track_points USING GIST (location);
 -- Enable compression with segmentby for efficient queries
@@ -52,18 +64,21 @@ SET (
 -- Auto-compress chunks older than 1 day
 -- 90%+ storage reduction
 SELECT add_compression_policy('track_points', INTERVAL '1 day');
--- Hourly summary per container (for dashboard)
--- While access to the data stored in a materialized view is often much faster than accessing the underlying tables directly or through a view, the data is not always current
-CREATE MATERIALIZED VIEW track_points_hourly WITH (timescaledb.continuous) AS
+-- Hourly summary per container (for dashboard and coarse zoom route levels)
+-- lat/lon is the last fix of the hour, so the summary stays on the route
+-- Not materialized_only: hours not refreshed yet are aggregated on read
+CREATE MATERIALIZED VIEW track_points_hourly WITH (
+    timescaledb.continuous,
+    timescaledb.materialized_only = false
+) AS
 SELECT time_bucket('1 hour', time) AS bucket,
     container_id,
     COUNT(*) AS point_count,
     AVG(speed) AS avg_speed,
     MAX(speed) AS max_speed,
-    ST_MakeLine(
-        location::geometry
-        ORDER BY time
-    ) AS route_line
+    last(lat, time) AS lat,
+    last(lon, time) AS lon,
+    MAX(time) AS last_time
 FROM track_points
 GROUP BY bucket,
     container_id WITH NO DATA;
@@ -0,0 +1,606 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	consumer "github.com/lai/logistics/consumer/db"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// How far back positions are looked up by default, and the longest
+	// time range one query may scan.
+	defaultPositionsAge = time.Hour
+	defaultRouteRange   = 24 * time.Hour
+	maxQueryDays        = 7
+	maxQueryRange       = maxQueryDays * 24 * time.Hour
+	// Simplified routes are one response, so they may span the retention
+	maxSimplifiedDays   = 31
+	maxSimplifiedPoints = 100000
+	// Raw points are held in memory to be simplified, so they are read for
+	// at most a week and up to this many; longer routes use the hourly
+	// summary.
+	maxRawSimplifiedDays   = maxQueryDays
+	maxRawSimplifiedPoints = 200000
+)
+
+// errRawRouteTooLong is returned by rawRoute when a route has more than
+// maxRawSimplifiedPoints points.
+var errRawRouteTooLong = errors.New("route has too many points to simplify")
+
+// Position is the latest fix of a container.
+type Position struct {
+	ContainerID string    `json:"container_id"`
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Timestamp   time.Time `json:"timestamp"`
+	Speed       *float64  `json:"speed,omitempty"`
+}
+
+// RoutePoint is one fix of a route.
+type RoutePoint struct {
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	Timestamp time.Time `json:"timestamp"`
+	Speed     *float64  `json:"speed,omitempty"`
+}
+
+// ContainerDetails is the metadata of a container with its latest fix.
+// Containers failing the ISO 6346 check are never registered, so their
+// metadata is empty.
+type ContainerDetails struct {
+	ContainerID   string     `json:"container_id"`
+	Owner         string     `json:"owner,omitempty"`
+	ContainerType string     `json:"container_type,omitempty"`
+	RegisteredAt  *time.Time `json:"registered_at,omitempty"`
+	LastPosition  *Position  `json:"last_position,omitempty"`
+}
+
+type positionsPage struct {
+	Positions  []Position `json:"positions"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type routePage struct {
+	ContainerID string       `json:"container_id"`
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Points      []RoutePoint `json:"points"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+	// Set for simplified routes: "raw" or "hourly", and the number of
+	// points before simplification
+	Resolution   string `json:"resolution,omitempty"`
+	SourcePoints int    `json:"source_points,omitempty"`
+}
+
+// APIHandler serves the stored track points:
+//
+//	GET /api/containers/positions   latest position of every container
+//	GET /api/containers/{id}/route  points of one container in [from, to)
+//	GET /api/containers/{id}/export the route as a file, see export
+//	GET /api/containers/{id}        metadata and latest position
+//
+// Lists are paged: limit sets the page size, and a response that has more
+// carries next_cursor, to be passed back as cursor. Responses are JSON, or
+// GeoJSON with format=geojson or "Accept: application/geo+json".
+type APIHandler struct {
+	q   consumer.Querier
+	now func() time.Time
+	mux *http.ServeMux
+}
+
+// NewAPIHandler creates the query API reading through q.
+func NewAPIHandler(q consumer.Querier) *APIHandler {
+	h := &APIHandler{q: q, now: time.Now, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /api/containers/positions", h.positions)
+	h.mux.HandleFunc("GET /api/containers/{id}/route", h.route)
+	h.mux.HandleFunc("GET /api/containers/{id}/export", h.export)
+	h.mux.HandleFunc("GET /api/containers/{id}", h.container)
+	return h
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// positions serves the latest fix of every container heard from since the
+// since parameter (default an hour ago), ordered by container ID.
+func (h *APIHandler) positions(w http.ResponseWriter, r *http.Request) {
+	geo, ok := wantsGeoJSON(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+	after, ok := decodeCursor(w, r)
+	if !ok {
+		return
+	}
+	now := h.now()
+	since := now.Add(-defaultPositionsAge)
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		if now.Sub(t) > maxQueryRange {
+			writeError(w, http.StatusBadRequest, "since must be within the last "+strconv.Itoa(maxQueryDays)+" days")
+			return
+		}
+		since = t
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := h.q.GetLatestPositions(r.Context(), consumer.GetLatestPositionsParams{
+		Since:   timestamptz(since),
+		After:   after,
+		MaxRows: int32(limit + 1),
+	})
+	if err != nil {
+		slog.Error("query latest positions failed", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+	page := positionsPage{Positions: make([]Position, 0, min(len(rows), limit))}
+	for i, row := range rows {
+		if i == limit {
+			page.NextCursor = encodeCursor(page.Positions[limit-1].ContainerID)
+			break
+		}
+		page.Positions = append(page.Positions, Position{
+			ContainerID: row.ContainerID,
+			Lat:         row.Lat,
+			Lon:         row.Lon,
+			Timestamp:   row.Time.Time,
+			Speed:       optFloat(row.Speed),
+		})
+	}
+
+	if geo {
+		writeGeoJSON(w, positionsFeatures(page))
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// route serves the fixes of a container from from (default a day before
+// to) up to to (default now), oldest first. With simplification options
+// the whole range is served at once, see simplifiedRoute.
+func (h *APIHandler) route(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	geo, ok := wantsGeoJSON(w, r)
+	if !ok {
+		return
+	}
+	opts, ok := parseSimplify(w, r)
+	if !ok {
+		return
+	}
+	if opts.enabled() {
+		h.simplifiedRoute(w, r, id, geo, opts)
+		return
+	}
+	limit, ok := pageSize(w, r)
+	if !ok {
+		return
+	}
+	from, to, ok := h.timeRange(w, r, defaultRouteRange, maxQueryDays)
+	if !ok {
+		return
+	}
+	start := routeCursor{Time: from}
+	if c, ok := decodeCursor(w, r); !ok {
+		return
+	} else if c != "" {
+		var err error
+		start, err = parseRouteCursor(c)
+		if err != nil || start.Time.Before(from) || !start.Time.Before(to) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := h.q.GetContainerRoute(r.Context(), start.params(id, to, limit+1))
+	if err != nil {
+		slog.Error("query container route failed", "container_id", id, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+	page := routePage{ContainerID: id, From: from, To: to}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		page.NextCursor = encodeCursor(start.next(rows).String())
+	}
+	page.Points = routePoints(rows)
+
+	if geo {
+		writeGeoJSON(w, routeFeatures(page))
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// simplifiedRoute serves the whole route in [from, to), up to
+// maxSimplifiedDays, thinned out for drawing: first by Douglas-Peucker with
+// tolerance, then by Visvalingam-Whyatt down to max_points. The points come
+// from track_points, or from the hourly summary when resolution is hourly,
+// or auto and max_points asks for less than one point per hour anyway.
+//
+// Raw points are held in memory, so resolution raw is limited to
+// maxRawSimplifiedDays and maxRawSimplifiedPoints; auto falls back to the
+// hourly summary beyond either.
+func (h *APIHandler) simplifiedRoute(w http.ResponseWriter, r *http.Request, id string, geo bool, opts simplifyOptions) {
+	if r.URL.Query().Has("cursor") || r.URL.Query().Has("limit") {
+		writeError(w, http.StatusBadRequest, "simplified routes are not paged, drop limit and cursor")
+		return
+	}
+	from, to, ok := h.timeRange(w, r, defaultRouteRange, maxSimplifiedDays)
+	if !ok {
+		return
+	}
+	rawTooLong := to.Sub(from) > maxRawSimplifiedDays*24*time.Hour
+	if opts.resolution == "raw" && rawTooLong {
+		writeError(w, http.StatusBadRequest, "resolution=raw is limited to "+strconv.Itoa(maxRawSimplifiedDays)+" days, use auto or hourly")
+		return
+	}
+
+	resolution := opts.resolution
+	if resolution == "auto" {
+		resolution = "raw"
+		if rawTooLong || opts.maxPoints > 0 && to.Sub(from) >= time.Duration(opts.maxPoints)*time.Hour {
+			resolution = "hourly"
+		}
+	}
+	var points []RoutePoint
+	var err error
+	if resolution == "raw" {
+		points, err = rawRoute(r.Context(), h.q, id, from, to)
+		if errors.Is(err, errRawRouteTooLong) {
+			if opts.resolution == "raw" {
+				writeError(w, http.StatusBadRequest, "route has more than "+strconv.Itoa(maxRawSimplifiedPoints)+" points, narrow the time range or use auto or hourly")
+				return
+			}
+			resolution = "hourly"
+		}
+	}
+	if resolution == "hourly" {
+		points, err = hourlyRoute(r.Context(), h.q, id, from, to)
+	}
+	if err != nil {
+		slog.Error("query container route failed", "container_id", id, "resolution", resolution, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+
+	page := routePage{ContainerID: id, From: from, To: to, Resolution: resolution, SourcePoints: len(points)}
+	if opts.tolerance > 0 {
+		points = douglasPeucker(points, opts.tolerance)
+	}
+	if opts.maxPoints > 0 {
+		points = visvalingam(points, opts.maxPoints)
+	}
+	page.Points = points
+	if page.Points == nil {
+		page.Points = []RoutePoint{}
+	}
+
+	if geo {
+		writeGeoJSON(w, routeFeatures(page))
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// rawRoute reads the track points of a route, failing with
+// errRawRouteTooLong as soon as there are more than maxRawSimplifiedPoints.
+func rawRoute(ctx context.Context, q consumer.Querier, id string, from, to time.Time) ([]RoutePoint, error) {
+	var points []RoutePoint
+	err := eachRoutePage(ctx, q, id, from, to, func(rows []consumer.GetContainerRouteRow) error {
+		if len(points)+len(rows) > maxRawSimplifiedPoints {
+			return errRawRouteTooLong
+		}
+		points = append(points, routePoints(rows)...)
+		return nil
+	})
+	return points, err
+}
+
+// hourlyRoute reads a route from the hourly summary.
+func hourlyRoute(ctx context.Context, q consumer.Querier, id string, from, to time.Time) ([]RoutePoint, error) {
+	rows, err := q.GetContainerRouteHourly(ctx, consumer.GetContainerRouteHourlyParams{
+		ContainerID: id,
+		FromTime:    timestamptz(from),
+		ToTime:      timestamptz(to),
+	})
+	if err != nil {
+		return nil, err
+	}
+	points := make([]RoutePoint, len(rows))
+	for i, row := range rows {
+		points[i] = RoutePoint{
+			Lat:       row.Lat,
+			Lon:       row.Lon,
+			Timestamp: row.Time.Time,
+			Speed:     optFloat(row.AvgSpeed),
+		}
+	}
+	return points, nil
+}
+
+// simplifyOptions are the route parameters asking for a simplified route.
+type simplifyOptions struct {
+	tolerance  float64 // meters, 0 when not set
+	maxPoints  int     // 0 when not set
+	resolution string  // "auto", "raw" or "hourly"
+}
+
+func (o simplifyOptions) enabled() bool {
+	return o.tolerance > 0 || o.maxPoints > 0 || o.resolution == "hourly"
+}
+
+// parseSimplify parses tolerance, max_points and resolution, writing a 400
+// when they are invalid.
+func parseSimplify(w http.ResponseWriter, r *http.Request) (simplifyOptions, bool) {
+	q := r.URL.Query()
+	opts := simplifyOptions{resolution: "auto"}
+	if v := q.Get("tolerance"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(t > 0) || math.IsInf(t, 0) {
+			writeError(w, http.StatusBadRequest, "tolerance must be a positive number of meters")
+			return opts, false
+		}
+		opts.tolerance = t
+	}
+	if v := q.Get("max_points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 || n > maxSimplifiedPoints {
+			writeError(w, http.StatusBadRequest, "max_points must be between 2 and "+strconv.Itoa(maxSimplifiedPoints))
+			return opts, false
+		}
+		opts.maxPoints = n
+	}
+	switch v := q.Get("resolution"); v {
+	case "":
+	case "auto", "raw", "hourly":
+		opts.resolution = v
+	default:
+		writeError(w, http.StatusBadRequest, "resolution must be auto, raw or hourly")
+		return opts, false
+	}
+	return opts, true
+}
+
+// container serves the metadata and latest fix of a container. It is 404
+// only when the container was neither registered nor ever reported.
+func (h *APIHandler) container(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	geo, ok := wantsGeoJSON(w, r)
+	if !ok {
+		return
+	}
+
+	details := ContainerDetails{ContainerID: id}
+	c, err := h.q.GetContainer(r.Context(), id)
+	switch {
+	case err == nil:
+		details.Owner = c.Owner.String
+		details.ContainerType = c.ContainerType.String
+		if c.CreatedAt.Valid {
+			details.RegisteredAt = &c.CreatedAt.Time
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		slog.Error("query container failed", "container_id", id, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+	registered := err == nil
+
+	pos, err := h.q.GetLatestPosition(r.Context(), id)
+	switch {
+	case err == nil:
+		details.LastPosition = &Position{
+			ContainerID: id,
+			Lat:         pos.Lat,
+			Lon:         pos.Lon,
+			Timestamp:   pos.Time.Time,
+			Speed:       optFloat(pos.Speed),
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		slog.Error("query latest position failed", "container_id", id, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	case !registered:
+		writeError(w, http.StatusNotFound, "container not found")
+		return
+	}
+
+	if geo {
+		writeGeoJSON(w, containerFeature(details))
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// timeRange parses from and to, writing a 400 when they are invalid. from
+// defaults to defaultRange before to, and the range may span maxDays.
+func (h *APIHandler) timeRange(w http.ResponseWriter, r *http.Request, defaultRange time.Duration, maxDays int) (from, to time.Time, ok bool) {
+	q := r.URL.Query()
+	to = h.now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+			return from, to, false
+		}
+		to = t
+	}
+	from = to.Add(-defaultRange)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+			return from, to, false
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return from, to, false
+	}
+	if to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		writeError(w, http.StatusBadRequest, "time range must not exceed "+strconv.Itoa(maxDays)+" days")
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// wantsGeoJSON reports whether the response should be GeoJSON, writing a
+// 400 for an unknown format.
+func wantsGeoJSON(w http.ResponseWriter, r *http.Request) (geo, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "geojson":
+		return true, true
+	case "json":
+		return false, true
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), "application/geo+json"), true
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or geojson")
+		return false, false
+	}
+}
+
+// pageSize parses limit, writing a 400 when it is out of range.
+func pageSize(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultPageSize, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPageSize {
+		writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+		return 0, false
+	}
+	return n, true
+}
+
+// Cursors are opaque to clients, so their content can change without
+// breaking them.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid cursor")
+		return "", false
+	}
+	return string(key), true
+}
+
+// routeCursor is where the next page of a route starts: at Time, after the
+// first Skip points stamped Time. A timestamp can repeat, e.g. for points
+// from two devices on one container, so starting after Time would drop the
+// rest of them.
+type routeCursor struct {
+	Time time.Time
+	Skip int
+}
+
+func parseRouteCursor(s string) (routeCursor, error) {
+	ts, skip, ok := strings.Cut(s, "/")
+	if !ok {
+		return routeCursor{}, errors.New("missing skip count")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return routeCursor{}, err
+	}
+	n, err := strconv.Atoi(skip)
+	if err != nil || n < 0 || n > math.MaxInt32 {
+		return routeCursor{}, errors.New("invalid skip count")
+	}
+	return routeCursor{Time: t, Skip: n}, nil
+}
+
+func (c routeCursor) String() string {
+	return c.Time.Format(time.RFC3339Nano) + "/" + strconv.Itoa(c.Skip)
+}
+
+// params queries up to limit points of the route of id from c up to to.
+func (c routeCursor) params(id string, to time.Time, limit int) consumer.GetContainerRouteParams {
+	return consumer.GetContainerRouteParams{
+		ContainerID: id,
+		FromTime:    timestamptz(c.Time),
+		ToTime:      timestamptz(to),
+		MaxRows:     int32(limit),
+		SkipRows:    int32(c.Skip),
+	}
+}
+
+// next returns the cursor following rows, a non-empty page read from c.
+func (c routeCursor) next(rows []consumer.GetContainerRouteRow) routeCursor {
+	last := rows[len(rows)-1].Time.Time
+	n := 0
+	for i := len(rows) - 1; i >= 0 && rows[i].Time.Time.Equal(last); i-- {
+		n++
+	}
+	if last.Equal(c.Time) {
+		// The whole page shares the timestamp of the one before
+		n += c.Skip
+	}
+	return routeCursor{Time: last, Skip: n}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func routePoints(rows []consumer.GetContainerRouteRow) []RoutePoint {
+	points := make([]RoutePoint, len(rows))
+	for i, row := range rows {
+		points[i] = RoutePoint{
+			Lat:       row.Lat,
+			Lon:       row.Lon,
+			Timestamp: row.Time.Time,
+			Speed:     optFloat(row.Speed),
+		}
+	}
+	return points
+}
+
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+func optFloat(v pgtype.Float8) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
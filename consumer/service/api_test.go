@@ -0,0 +1,499 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	consumer "github.com/lai/logistics/consumer/db"
+)
+
+var apiNow = time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func newTestAPI(q consumer.Querier) *APIHandler {
+	h := NewAPIHandler(q)
+	h.now = func() time.Time { return apiNow }
+	return h
+}
+
+func routeRow(t time.Time, lat float64) consumer.GetContainerRouteRow {
+	return consumer.GetContainerRouteRow{
+		Time: timestamptz(t),
+		Lat:  lat,
+		Lon:  4.4,
+	}
+}
+
+func TestAPIHandler_RoutePagesThroughSharedTimestamps(t *testing.T) {
+	t0 := apiNow.Add(-time.Hour)
+	t1 := t0.Add(time.Second)
+	t2 := t1.Add(time.Second)
+	// Three points at t1, as sent by two devices on one container
+	route := []consumer.GetContainerRouteRow{
+		routeRow(t0, 51.0),
+		routeRow(t1, 51.1),
+		routeRow(t1, 51.2),
+		routeRow(t1, 51.3),
+		routeRow(t2, 51.4),
+	}
+
+	for _, limit := range []int{1, 2, 3, 4} {
+		t.Run("limit "+strconv.Itoa(limit), func(t *testing.T) {
+			h := newTestAPI(&fakeQuerier{route: route})
+			var got []float64
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(route) {
+					t.Fatal("paging does not end")
+				}
+				q := url.Values{"limit": {strconv.Itoa(limit)}}
+				if cursor != "" {
+					q.Set("cursor", cursor)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/containers/C1/route?"+q.Encode(), nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+				}
+				var page routePage
+				if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+					t.Fatal(err)
+				}
+				for _, p := range page.Points {
+					got = append(got, p.Lat)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			want := []float64{51.0, 51.1, 51.2, 51.3, 51.4}
+			if len(got) != len(want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("got %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteCursor_Next(t *testing.T) {
+	t0 := apiNow
+	t1 := t0.Add(time.Second)
+	tests := []struct {
+		name  string
+		start routeCursor
+		rows  []consumer.GetContainerRouteRow
+		want  routeCursor
+	}{
+		{"distinct", routeCursor{Time: t0}, []consumer.GetContainerRouteRow{routeRow(t0, 1), routeRow(t1, 2)}, routeCursor{t1, 1}},
+		{"shared last", routeCursor{Time: t0}, []consumer.GetContainerRouteRow{routeRow(t0, 1), routeRow(t1, 2), routeRow(t1, 3)}, routeCursor{t1, 2}},
+		{"whole page shared", routeCursor{t1, 2}, []consumer.GetContainerRouteRow{routeRow(t1, 4), routeRow(t1, 5)}, routeCursor{t1, 4}},
+		{"moves past shared", routeCursor{t0, 3}, []consumer.GetContainerRouteRow{routeRow(t0, 4), routeRow(t1, 5)}, routeCursor{t1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.start.next(tt.rows)
+			if !got.Time.Equal(tt.want.Time) || got.Skip != tt.want.Skip {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			parsed, err := parseRouteCursor(got.String())
+			if err != nil || !parsed.Time.Equal(got.Time) || parsed.Skip != got.Skip {
+				t.Errorf("cursor %q parsed to %v, %v", got, parsed, err)
+			}
+		})
+	}
+}
+
+// get serves target with h and decodes the JSON response into v, if any.
+func get(t *testing.T, h http.Handler, target string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body, err)
+		}
+	}
+	return rec
+}
+
+func apiFixture() *fakeQuerier {
+	recent := timestamptz(apiNow.Add(-10 * time.Minute))
+	return &fakeQuerier{
+		containers: map[string]consumer.Container{
+			"CSQU3054383": {ContainerID: "CSQU3054383", Owner: pgtype.Text{String: "Maersk", Valid: true}, CreatedAt: recent},
+			"MSCU1234565": {ContainerID: "MSCU1234565"},
+		},
+		latest: map[string]consumer.GetLatestPositionRow{
+			"CSQU3054383": {Time: recent, Lat: 51.9, Lon: 4.4, Speed: pgtype.Float8{Float64: 3.5, Valid: true}},
+			"C001":        {Time: recent, Lat: 52.1, Lon: 4.3},
+		},
+		positions: []consumer.GetLatestPositionsRow{
+			{ContainerID: "C", Time: recent, Lat: 3, Lon: 3},
+			{ContainerID: "A", Time: recent, Lat: 1, Lon: 1},
+			{ContainerID: "B", Time: recent, Lat: 2, Lon: 2},
+			{ContainerID: "D", Time: timestamptz(apiNow.Add(-2 * time.Hour)), Lat: 4, Lon: 4},
+		},
+		route: []consumer.GetContainerRouteRow{
+			routeRow(apiNow.Add(-2*time.Hour), 51.0),
+			routeRow(apiNow.Add(-time.Hour), 51.1),
+		},
+	}
+}
+
+func TestAPIHandler_Status(t *testing.T) {
+	outOfRange := encodeCursor(routeCursor{Time: apiNow.Add(-48 * time.Hour)}.String())
+
+	tests := []struct {
+		name       string
+		target     string
+		err        error
+		wantStatus int
+	}{
+		{"positions", "/api/containers/positions", nil, http.StatusOK},
+		{"positions bad limit", "/api/containers/positions?limit=0", nil, http.StatusBadRequest},
+		{"positions limit too large", "/api/containers/positions?limit=1001", nil, http.StatusBadRequest},
+		{"positions bad since", "/api/containers/positions?since=yesterday", nil, http.StatusBadRequest},
+		{"positions since too old", "/api/containers/positions?since=2025-12-01T00:00:00Z", nil, http.StatusBadRequest},
+		{"positions bad cursor", "/api/containers/positions?cursor=!!", nil, http.StatusBadRequest},
+		{"positions bad format", "/api/containers/positions?format=xml", nil, http.StatusBadRequest},
+		{"positions db down", "/api/containers/positions", errors.New("db down"), http.StatusServiceUnavailable},
+		{"route", "/api/containers/C1/route", nil, http.StatusOK},
+		{"route bad limit", "/api/containers/C1/route?limit=x", nil, http.StatusBadRequest},
+		{"route bad cursor", "/api/containers/C1/route?cursor=!!", nil, http.StatusBadRequest},
+		{"route cursor without skip", "/api/containers/C1/route?cursor=" + encodeCursor(apiNow.Add(-time.Hour).Format(time.RFC3339Nano)), nil, http.StatusBadRequest},
+		{"route cursor out of range", "/api/containers/C1/route?cursor=" + outOfRange, nil, http.StatusBadRequest},
+		{"route from after to", "/api/containers/C1/route?from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z", nil, http.StatusBadRequest},
+		{"route range too long", "/api/containers/C1/route?from=2025-12-20T00:00:00Z", nil, http.StatusBadRequest},
+		{"route db down", "/api/containers/C1/route", errors.New("db down"), http.StatusServiceUnavailable},
+		{"simplified route paged", "/api/containers/C1/route?max_points=10&limit=5", nil, http.StatusBadRequest},
+		{"simplified route bad tolerance", "/api/containers/C1/route?tolerance=-1", nil, http.StatusBadRequest},
+		{"simplified route", "/api/containers/C1/route?max_points=10", nil, http.StatusOK},
+		{"container registered", "/api/containers/CSQU3054383", nil, http.StatusOK},
+		{"container registered without position", "/api/containers/MSCU1234565", nil, http.StatusOK},
+		{"container only reported", "/api/containers/C001", nil, http.StatusOK},
+		{"container unknown", "/api/containers/NOPE", nil, http.StatusNotFound},
+		{"container bad format", "/api/containers/C001?format=kml", nil, http.StatusBadRequest},
+		{"container db down", "/api/containers/C001", errors.New("db down"), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := apiFixture()
+			q.err = tt.err
+			rec := get(t, newTestAPI(q), tt.target, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+			if rec.Code != http.StatusOK {
+				var body map[string]string
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+					t.Errorf("got error body %s", rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIHandler_PositionsPages(t *testing.T) {
+	h := newTestAPI(apiFixture())
+
+	var page positionsPage
+	get(t, h, "/api/containers/positions?limit=2", &page)
+	if len(page.Positions) != 2 || page.Positions[0].ContainerID != "A" || page.Positions[1].ContainerID != "B" {
+		t.Fatalf("got first page %+v", page.Positions)
+	}
+	if page.NextCursor == "" {
+		t.Fatal("missing next_cursor")
+	}
+
+	var next positionsPage
+	get(t, h, "/api/containers/positions?limit=2&cursor="+page.NextCursor, &next)
+	// D is older than the default hour
+	if len(next.Positions) != 1 || next.Positions[0].ContainerID != "C" || next.NextCursor != "" {
+		t.Errorf("got second page %+v", next)
+	}
+
+	var all positionsPage
+	get(t, h, "/api/containers/positions?since="+apiNow.Add(-3*time.Hour).Format(time.RFC3339), &all)
+	if len(all.Positions) != 4 {
+		t.Errorf("got %d positions since 3h ago, want 4", len(all.Positions))
+	}
+}
+
+func TestAPIHandler_Container(t *testing.T) {
+	h := newTestAPI(apiFixture())
+
+	var d ContainerDetails
+	get(t, h, "/api/containers/CSQU3054383", &d)
+	if d.Owner != "Maersk" || d.RegisteredAt == nil || d.LastPosition == nil {
+		t.Fatalf("got %+v", d)
+	}
+	if d.LastPosition.Lat != 51.9 || d.LastPosition.Speed == nil || *d.LastPosition.Speed != 3.5 {
+		t.Errorf("got last position %+v", d.LastPosition)
+	}
+
+	d = ContainerDetails{}
+	get(t, h, "/api/containers/MSCU1234565", &d)
+	if d.LastPosition != nil || d.RegisteredAt != nil {
+		t.Errorf("got %+v, want no position or registration time", d)
+	}
+
+	d = ContainerDetails{}
+	get(t, h, "/api/containers/C001", &d)
+	if d.Owner != "" || d.LastPosition == nil || d.LastPosition.ContainerID != "C001" {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestAPIHandler_GeoJSON(t *testing.T) {
+	h := newTestAPI(apiFixture())
+
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		wantType string
+		geometry string
+	}{
+		{"positions", "/api/containers/positions?format=geojson", "", "FeatureCollection", "Point"},
+		{"route", "/api/containers/C1/route", "application/geo+json", "FeatureCollection", "LineString"},
+		{"container", "/api/containers/C001?format=geojson", "", "Feature", "Point"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/geo+json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+
+			var body struct {
+				Type     string
+				Geometry *geometry
+				Features []feature
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Type != tt.wantType {
+				t.Errorf("got type %q, want %q", body.Type, tt.wantType)
+			}
+			g := body.Geometry
+			if len(body.Features) > 0 {
+				g = body.Features[0].Geometry
+			}
+			if g == nil || g.Type != tt.geometry {
+				t.Errorf("got geometry %+v, want %s", g, tt.geometry)
+			}
+		})
+	}
+}
+
+func TestAPIHandler_TimeRange(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name     string
+		query    string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantOK   bool
+	}{
+		{"defaults", "", apiNow.Add(-day), apiNow, true},
+		{"to only", "to=2026-01-01T12:00:00Z", apiNow.Add(-36 * time.Hour), apiNow.Add(-12 * time.Hour), true},
+		{"from only", "from=2026-01-01T23:00:00Z", apiNow.Add(-time.Hour), apiNow, true},
+		{"max range", "from=2025-12-26T00:00:00Z", apiNow.Add(-7 * day), apiNow, true},
+		{"range too long", "from=2025-12-25T23:59:59Z", time.Time{}, time.Time{}, false},
+		{"from equals to", "from=2026-01-02T00:00:00Z", time.Time{}, time.Time{}, false},
+		{"bad from", "from=2026-01-01", time.Time{}, time.Time{}, false},
+		{"bad to", "to=now", time.Time{}, time.Time{}, false},
+	}
+
+	h := newTestAPI(&fakeQuerier{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			from, to, ok := h.timeRange(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil), day, 7)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if rec.Code != http.StatusBadRequest {
+					t.Errorf("got status %d, want 400", rec.Code)
+				}
+				return
+			}
+			if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+				t.Errorf("got [%v, %v), want [%v, %v)", from, to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestParseSimplify(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    simplifyOptions
+		enabled bool
+		wantOK  bool
+	}{
+		{"", simplifyOptions{resolution: "auto"}, false, true},
+		{"tolerance=12.5", simplifyOptions{tolerance: 12.5, resolution: "auto"}, true, true},
+		{"max_points=2", simplifyOptions{maxPoints: 2, resolution: "auto"}, true, true},
+		{"resolution=hourly", simplifyOptions{resolution: "hourly"}, true, true},
+		{"resolution=raw", simplifyOptions{resolution: "raw"}, false, true},
+		{"tolerance=0", simplifyOptions{}, false, false},
+		{"tolerance=-3", simplifyOptions{}, false, false},
+		{"tolerance=NaN", simplifyOptions{}, false, false},
+		{"tolerance=Inf", simplifyOptions{}, false, false},
+		{"tolerance=far", simplifyOptions{}, false, false},
+		{"max_points=1", simplifyOptions{}, false, false},
+		{"max_points=" + strconv.Itoa(maxSimplifiedPoints+1), simplifyOptions{}, false, false},
+		{"max_points=1.5", simplifyOptions{}, false, false},
+		{"resolution=minute", simplifyOptions{}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			got, ok := parseSimplify(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if rec.Code != http.StatusBadRequest {
+					t.Errorf("got status %d, want 400", rec.Code)
+				}
+				return
+			}
+			if got != tt.want || got.enabled() != tt.enabled {
+				t.Errorf("got %+v (enabled %v), want %+v (enabled %v)", got, got.enabled(), tt.want, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestWantsGeoJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		accept  string
+		wantGeo bool
+		wantOK  bool
+	}{
+		{"default", "", "", false, true},
+		{"accept header", "", "application/json, application/geo+json", true, true},
+		{"format geojson", "geojson", "", true, true},
+		{"format overrides accept", "json", "application/geo+json", false, true},
+		{"unknown format", "gpx", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"format": {tt.format}}.Encode(), nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			geo, ok := wantsGeoJSON(rec, req)
+			if geo != tt.wantGeo || ok != tt.wantOK {
+				t.Errorf("got %v, %v, want %v, %v", geo, ok, tt.wantGeo, tt.wantOK)
+			}
+			if !ok && rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, key := range []string{"", "MSCU1234565", "2026-01-01T08:00:30.123456Z/3"} {
+		rec := httptest.NewRecorder()
+		got, ok := decodeCursor(rec, httptest.NewRequest(http.MethodGet, "/?cursor="+encodeCursor(key), nil))
+		if !ok || got != key {
+			t.Errorf("cursor %q decoded to %q, %v", key, got, ok)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	if _, ok := decodeCursor(rec, httptest.NewRequest(http.MethodGet, "/?cursor=not+base64", nil)); ok || rec.Code != http.StatusBadRequest {
+		t.Errorf("got ok %v, status %d for an invalid cursor", ok, rec.Code)
+	}
+
+	for _, s := range []string{
+		"2026-01-01T08:00:30Z",
+		"2026-01-01T08:00:30Z/",
+		"2026-01-01T08:00:30Z/-1",
+		"2026-01-01T08:00:30Z/two",
+		"2026-01-01T08:00:30Z/" + strconv.Itoa(math.MaxInt32+1),
+		"yesterday/1",
+	} {
+		if c, err := parseRouteCursor(s); err == nil {
+			t.Errorf("route cursor %q parsed to %v", s, c)
+		}
+	}
+}
+
+func TestAPIHandler_SimplifiedResolution(t *testing.T) {
+	q := apiFixture()
+	q.hourly = []consumer.GetContainerRouteHourlyRow{
+		{Time: timestamptz(apiNow.Add(-time.Hour)), Lat: 51.1, Lon: 4.4},
+	}
+	// More raw points in the last hour than are simplified from memory
+	var many []consumer.GetContainerRouteRow
+	for i := range maxRawSimplifiedPoints + 1 {
+		many = append(many, routeRow(apiNow.Add(-time.Hour+time.Duration(i)*time.Microsecond), 51))
+	}
+	crowded := &fakeQuerier{route: many, hourly: q.hourly}
+
+	tests := []struct {
+		name           string
+		q              *fakeQuerier
+		query          string
+		wantStatus     int
+		wantResolution string
+	}{
+		{"auto within a week", q, "tolerance=5&from=2025-12-30T00:00:00Z", http.StatusOK, "raw"},
+		{"auto few points per hour", q, "max_points=10&from=2025-12-30T00:00:00Z", http.StatusOK, "hourly"},
+		{"auto beyond a week", q, "tolerance=5&from=2025-12-20T00:00:00Z", http.StatusOK, "hourly"},
+		{"raw beyond a week", q, "resolution=raw&tolerance=5&from=2025-12-20T00:00:00Z", http.StatusBadRequest, ""},
+		{"hourly beyond a week", q, "resolution=hourly&from=2025-12-05T00:00:00Z", http.StatusOK, "hourly"},
+		{"auto too many raw points", crowded, "tolerance=5", http.StatusOK, "hourly"},
+		{"raw too many raw points", crowded, "resolution=raw&tolerance=5", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var page routePage
+			rec := get(t, newTestAPI(tt.q), "/api/containers/C1/route?"+tt.query, &page)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if page.Resolution != tt.wantResolution {
+				t.Errorf("got resolution %q, want %q", page.Resolution, tt.wantResolution)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+
+	consumer "github.com/lai/logistics/consumer/db"
+	"github.com/lai/logistics/pkg/iso6346"
+)
+
+// registrySeenSize bounds the IDs a ContainerRegistry remembers. Forgotten
+// IDs are registered again, which the insert ignores.
+const registrySeenSize = 100000
+
+// ContainerRegistry adds a containers row the first time a container ID is
+// seen. IDs are normalized like telemetry does, and only those passing the
+// same ISO 6346 check as its CONTAINER_ID_MODE are registered, so in strict
+// mode a typo in a device config doesn't create a phantom container; its
+// track points are still stored.
+type ContainerRegistry struct {
+	q    consumer.Querier
+	mode iso6346.Mode
+	size int
+
+	mu   sync.Mutex
+	seen map[string]*list.Element // IDs already registered or rejected by this process
+	lru  *list.List               // front = most recently seen
+}
+
+// NewContainerRegistry creates a registry writing through q.
+func NewContainerRegistry(q consumer.Querier, mode iso6346.Mode) *ContainerRegistry {
+	return &ContainerRegistry{
+		q:    q,
+		mode: mode,
+		size: registrySeenSize,
+		seen: make(map[string]*list.Element),
+		lru:  list.New(),
+	}
+}
+
+// Register inserts the new valid container IDs of a batch.
+func (r *ContainerRegistry) Register(ctx context.Context, points []TrackPoint) error {
+	r.mu.Lock()
+	var ids []string
+	for _, p := range points {
+		id := iso6346.Normalize(p.ContainerID)
+		if !r.remember(id) {
+			continue
+		}
+		if err := r.mode.Check(id); err != nil {
+			slog.Warn("not registering container", "container_id", p.ContainerID, "error", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.q.RegisterContainers(ctx, ids); err != nil {
+		// Forget them so the next batch retries
+		r.mu.Lock()
+		for _, id := range ids {
+			if el, ok := r.seen[id]; ok {
+				r.lru.Remove(el)
+				delete(r.seen, id)
+			}
+		}
+		r.mu.Unlock()
+		return err
+	}
+	slog.Info("registered containers", "count", len(ids))
+	return nil
+}
+
+// remember marks id as seen and reports whether it was new, evicting the
+// least recently seen ID past the size. Called with r.mu held.
+func (r *ContainerRegistry) remember(id string) bool {
+	if el, ok := r.seen[id]; ok {
+		r.lru.MoveToFront(el)
+		return false
+	}
+	r.seen[id] = r.lru.PushFront(id)
+	if r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.seen, oldest.Value.(string))
+	}
+	return true
+}
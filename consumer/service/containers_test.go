@@ -0,0 +1,191 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	consumer "github.com/lai/logistics/consumer/db"
+	"github.com/lai/logistics/pkg/iso6346"
+)
+
+// fakeQuerier implements consumer.Querier for testing. Queries a test
+// doesn't set up panic through the nil embedded interface.
+type fakeQuerier struct {
+	consumer.Querier
+
+	registered  [][]string
+	registerErr error
+
+	// Read by the query API, for every container
+	containers map[string]consumer.Container
+	latest     map[string]consumer.GetLatestPositionRow
+	positions  []consumer.GetLatestPositionsRow
+	route      []consumer.GetContainerRouteRow
+	hourly     []consumer.GetContainerRouteHourlyRow
+	err        error // returned by every read
+}
+
+func (f *fakeQuerier) RegisterContainers(ctx context.Context, ids []string) error {
+	if f.registerErr != nil {
+		return f.registerErr
+	}
+	f.registered = append(f.registered, ids)
+	return nil
+}
+
+// GetContainerRoute filters and orders route like the query does.
+func (f *fakeQuerier) GetContainerRoute(ctx context.Context, arg consumer.GetContainerRouteParams) ([]consumer.GetContainerRouteRow, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var rows []consumer.GetContainerRouteRow
+	for _, row := range f.route {
+		if !row.Time.Time.Before(arg.FromTime.Time) && row.Time.Time.Before(arg.ToTime.Time) {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortStableFunc(rows, func(a, b consumer.GetContainerRouteRow) int {
+		return cmp.Or(
+			a.Time.Time.Compare(b.Time.Time),
+			cmp.Compare(a.Lat, b.Lat),
+			cmp.Compare(a.Lon, b.Lon),
+			cmp.Compare(a.Speed.Float64, b.Speed.Float64),
+		)
+	})
+	rows = rows[min(int(arg.SkipRows), len(rows)):]
+	return rows[:min(int(arg.MaxRows), len(rows))], nil
+}
+
+func (f *fakeQuerier) GetContainerRouteHourly(ctx context.Context, arg consumer.GetContainerRouteHourlyParams) ([]consumer.GetContainerRouteHourlyRow, error) {
+	return f.hourly, f.err
+}
+
+func (f *fakeQuerier) GetContainer(ctx context.Context, id string) (consumer.Container, error) {
+	if f.err != nil {
+		return consumer.Container{}, f.err
+	}
+	c, ok := f.containers[id]
+	if !ok {
+		return c, pgx.ErrNoRows
+	}
+	return c, nil
+}
+
+func (f *fakeQuerier) GetLatestPosition(ctx context.Context, id string) (consumer.GetLatestPositionRow, error) {
+	if f.err != nil {
+		return consumer.GetLatestPositionRow{}, f.err
+	}
+	pos, ok := f.latest[id]
+	if !ok {
+		return pos, pgx.ErrNoRows
+	}
+	return pos, nil
+}
+
+// GetLatestPositions pages positions, sorted by container ID, like the
+// query does.
+func (f *fakeQuerier) GetLatestPositions(ctx context.Context, arg consumer.GetLatestPositionsParams) ([]consumer.GetLatestPositionsRow, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var rows []consumer.GetLatestPositionsRow
+	for _, row := range f.positions {
+		if row.ContainerID > arg.After && !row.Time.Time.Before(arg.Since.Time) {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b consumer.GetLatestPositionsRow) int {
+		return cmp.Compare(a.ContainerID, b.ContainerID)
+	})
+	return rows[:min(int(arg.MaxRows), len(rows))], nil
+}
+
+func pointsFor(ids ...string) []TrackPoint {
+	points := make([]TrackPoint, len(ids))
+	for i, id := range ids {
+		points[i] = TrackPoint{ContainerID: id}
+	}
+	return points
+}
+
+func TestContainerRegistry_Mode(t *testing.T) {
+	ids := []string{"CSQU3054383", "CSQU3054384", "C001"} // valid, wrong check digit, made up
+
+	tests := []struct {
+		mode iso6346.Mode
+		want []string
+	}{
+		{iso6346.ModeLenient, ids},
+		{iso6346.ModeFormat, ids[:2]},
+		{iso6346.ModeStrict, ids[:1]},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			q := &fakeQuerier{}
+			r := NewContainerRegistry(q, tt.mode)
+
+			if err := r.Register(context.Background(), pointsFor(append(ids, ids...)...)); err != nil {
+				t.Fatal(err)
+			}
+			if len(q.registered) != 1 || !reflect.DeepEqual(q.registered[0], tt.want) {
+				t.Errorf("got registered %v, want [%v]", q.registered, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerRegistry_NormalizesIDs(t *testing.T) {
+	q := &fakeQuerier{}
+	r := NewContainerRegistry(q, iso6346.ModeStrict)
+
+	if err := r.Register(context.Background(), pointsFor("csqu3054383", "CSQU 305438 3", "CSQU3054383")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"CSQU3054383"}; len(q.registered) != 1 || !reflect.DeepEqual(q.registered[0], want) {
+		t.Errorf("got registered %v, want [%v]", q.registered, want)
+	}
+}
+
+func TestContainerRegistry_RetriesFailedInsert(t *testing.T) {
+	q := &fakeQuerier{registerErr: errors.New("db down")}
+	r := NewContainerRegistry(q, iso6346.ModeLenient)
+	ctx := context.Background()
+
+	if err := r.Register(ctx, pointsFor("A")); err == nil {
+		t.Fatal("expected error")
+	}
+	q.registerErr = nil
+	if err := r.Register(ctx, pointsFor("A")); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.registered) != 1 {
+		t.Errorf("got registered %v, want A once", q.registered)
+	}
+}
+
+func TestContainerRegistry_BoundsSeen(t *testing.T) {
+	q := &fakeQuerier{}
+	r := NewContainerRegistry(q, iso6346.ModeLenient)
+	r.size = 2
+	ctx := context.Background()
+
+	for _, id := range []string{"A", "B", "A", "C", "A", "B"} {
+		if err := r.Register(ctx, pointsFor(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(r.seen) != 2 || r.lru.Len() != 2 {
+		t.Errorf("got %d seen, %d in LRU, want 2", len(r.seen), r.lru.Len())
+	}
+	// A stays recent, so only B is evicted (by C) and registered again
+	want := [][]string{{"A"}, {"B"}, {"C"}, {"B"}}
+	if !reflect.DeepEqual(q.registered, want) {
+		t.Errorf("got registered %v, want %v", q.registered, want)
+	}
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	consumer "github.com/lai/logistics/consumer/db"
+)
+
+const (
+	defaultExportRange = 7 * 24 * time.Hour
+	// Track points are kept for 30 days
+	maxExportDays = 31
+	// Points read per query; bounds the memory an export needs
+	routePageSize = 5000
+	// Extended before every page, as a long export outlives the server's
+	// WriteTimeout
+	exportWriteTimeout = 30 * time.Second
+)
+
+// routeWriter streams a route in one file format. begin and end are
+// called once, page for every non-empty page of points, oldest first.
+type routeWriter interface {
+	begin() error
+	page(points []RoutePoint) error
+	end() error
+}
+
+// routeMeta describes the exported route.
+type routeMeta struct {
+	ContainerID string
+	From, To    time.Time
+}
+
+type exportFormat struct {
+	contentType string
+	ext         string
+	newWriter   func(w *bufio.Writer, meta routeMeta) routeWriter
+}
+
+var exportFormats = map[string]exportFormat{
+	"geojson": {"application/geo+json", "geojson", newGeoJSONRoute},
+	"gpx":     {"application/gpx+xml", "gpx", newGPXRoute},
+	"kml":     {"application/vnd.google-earth.kml+xml", "kml", newKMLRoute},
+	"csv":     {"text/csv; charset=utf-8", "csv", newCSVRoute},
+}
+
+// export streams the route of a container from from (default a week
+// before to) up to to (default now) as a file download. format picks
+// geojson (the default), gpx, kml or csv. The route is read page by page,
+// so ranges of weeks don't have to fit in memory.
+//
+// Failures before the first byte are answered with an error status; later
+// ones abort the connection, so the client sees a truncated download
+// rather than a file that looks complete.
+func (h *APIHandler) export(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "geojson"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "format must be geojson, gpx, kml or csv")
+		return
+	}
+	from, to, ok := h.timeRange(w, r, defaultExportRange, maxExportDays)
+	if !ok {
+		return
+	}
+
+	var (
+		rc      = http.NewResponseController(w)
+		bw      = bufio.NewWriter(w)
+		rw      = format.newWriter(bw, routeMeta{ContainerID: id, From: from, To: to})
+		started bool
+		points  int
+	)
+	err := eachRoutePage(r.Context(), h.q, id, from, to, func(rows []consumer.GetContainerRouteRow) error {
+		if !started {
+			// The first page was read, so the download can start
+			w.Header().Set("Content-Type", format.contentType)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+				"filename": exportFilename(id, from, to, format.ext),
+			}))
+			started = true
+			if err := rw.begin(); err != nil {
+				return err
+			}
+		}
+		// Not every ResponseWriter supports deadlines; the default is fine then
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if len(rows) > 0 {
+			if err := rw.page(routePoints(rows)); err != nil {
+				return err
+			}
+			points += len(rows)
+		}
+		return bw.Flush()
+	})
+	if err == nil {
+		if err = rw.end(); err == nil {
+			err = bw.Flush()
+		}
+	}
+	switch {
+	case err != nil && !started:
+		slog.Error("query container route failed", "container_id", id, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+	case err != nil:
+		slog.Error("export route failed", "container_id", id, "format", name, "points", points, "error", err)
+		panic(http.ErrAbortHandler)
+	default:
+		slog.Info("exported route", "container_id", id, "format", name, "points", points)
+	}
+}
+
+// eachRoutePage reads the route of a container in [from, to) a page of
+// routePageSize points at a time, oldest first, and calls fn for every
+// page. The first page may be empty.
+func eachRoutePage(ctx context.Context, q consumer.Querier, id string, from, to time.Time, fn func(rows []consumer.GetContainerRouteRow) error) error {
+	cursor := routeCursor{Time: from}
+	for {
+		rows, err := q.GetContainerRoute(ctx, cursor.params(id, to, routePageSize))
+		if err != nil {
+			return fmt.Errorf("query container route: %w", err)
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		if len(rows) < routePageSize {
+			return nil
+		}
+		cursor = cursor.next(rows)
+	}
+}
+
+// exportFilename is like MSCU1234567_20260101T000000Z_20260108T000000Z.gpx.
+func exportFilename(id string, from, to time.Time, ext string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+	const layout = "20060102T150405Z"
+	return safe + "_" + from.UTC().Format(layout) + "_" + to.UTC().Format(layout) + "." + ext
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// geoJSONRoute writes a FeatureCollection with a LineString per page, each
+// starting at the last point of the one before so the line is unbroken.
+// The properties are those of the route endpoint's GeoJSON.
+type geoJSONRoute struct {
+	w        *bufio.Writer
+	meta     routeMeta
+	last     *RoutePoint
+	features int
+}
+
+func newGeoJSONRoute(w *bufio.Writer, meta routeMeta) routeWriter {
+	return &geoJSONRoute{w: w, meta: meta}
+}
+
+func (g *geoJSONRoute) begin() error {
+	_, err := g.w.WriteString(`{"type":"FeatureCollection","features":[` + "\n")
+	return err
+}
+
+func (g *geoJSONRoute) page(points []RoutePoint) error {
+	last := points[len(points)-1]
+	if g.last != nil {
+		points = append([]RoutePoint{*g.last}, points...)
+	}
+	g.last = &last
+
+	data, err := json.Marshal(routeFeature(routePage{
+		ContainerID: g.meta.ContainerID,
+		From:        g.meta.From,
+		To:          g.meta.To,
+		Points:      points,
+	}))
+	if err != nil {
+		return err
+	}
+	if g.features > 0 {
+		g.w.WriteString(",\n")
+	}
+	g.features++
+	_, err = g.w.Write(data)
+	return err
+}
+
+func (g *geoJSONRoute) end() error {
+	_, err := g.w.WriteString("\n]}\n")
+	return err
+}
+
+// gpxRoute writes a GPX 1.1 track with one segment. GPX 1.1 has no speed
+// element, so speed goes into the Garmin TrackPointExtension.
+type gpxRoute struct {
+	w    *bufio.Writer
+	meta routeMeta
+}
+
+func newGPXRoute(w *bufio.Writer, meta routeMeta) routeWriter {
+	return &gpxRoute{w: w, meta: meta}
+}
+
+func (g *gpxRoute) begin() error {
+	g.w.WriteString(xml.Header)
+	g.w.WriteString(`<gpx version="1.1" creator="logistics consumer"` +
+		` xmlns="http://www.topografix.com/GPX/1/1"` +
+		` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">` + "\n")
+	g.w.WriteString("<trk>\n<name>")
+	if err := xml.EscapeText(g.w, []byte(g.meta.ContainerID)); err != nil {
+		return err
+	}
+	_, err := g.w.WriteString("</name>\n<trkseg>\n")
+	return err
+}
+
+func (g *gpxRoute) page(points []RoutePoint) error {
+	for _, p := range points {
+		fmt.Fprintf(g.w, `<trkpt lat="%s" lon="%s"><time>%s</time>`,
+			formatFloat(p.Lat), formatFloat(p.Lon), formatTime(p.Timestamp))
+		if p.Speed != nil {
+			fmt.Fprintf(g.w, "<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%s</gpxtpx:speed></gpxtpx:TrackPointExtension></extensions>",
+				formatFloat(*p.Speed))
+		}
+		if _, err := g.w.WriteString("</trkpt>\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gpxRoute) end() error {
+	_, err := g.w.WriteString("</trkseg>\n</trk>\n</gpx>\n")
+	return err
+}
+
+// kmlRoute writes a placemark with a gx:MultiTrack holding a gx:Track per
+// page; gx:interpolate joins them into one line. Speed is a
+// gx:SimpleArrayData.
+type kmlRoute struct {
+	w    *bufio.Writer
+	meta routeMeta
+}
+
+func newKMLRoute(w *bufio.Writer, meta routeMeta) routeWriter {
+	return &kmlRoute{w: w, meta: meta}
+}
+
+func (k *kmlRoute) begin() error {
+	k.w.WriteString(xml.Header)
+	k.w.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n")
+	k.w.WriteString("<Document>\n<name>")
+	xml.EscapeText(k.w, []byte(k.meta.ContainerID))
+	k.w.WriteString("</name>\n")
+	k.w.WriteString(`<Schema id="route"><gx:SimpleArrayField name="speed" type="float">` +
+		"<displayName>Speed (m/s)</displayName></gx:SimpleArrayField></Schema>\n")
+	k.w.WriteString("<Placemark>\n<name>")
+	xml.EscapeText(k.w, []byte(k.meta.ContainerID))
+	fmt.Fprintf(k.w, "</name>\n<TimeSpan><begin>%s</begin><end>%s</end></TimeSpan>\n",
+		formatTime(k.meta.From), formatTime(k.meta.To))
+	_, err := k.w.WriteString("<gx:MultiTrack>\n<gx:interpolate>1</gx:interpolate>\n")
+	return err
+}
+
+func (k *kmlRoute) page(points []RoutePoint) error {
+	k.w.WriteString("<gx:Track>\n")
+	for _, p := range points {
+		fmt.Fprintf(k.w, "<when>%s</when>\n", formatTime(p.Timestamp))
+	}
+	for _, p := range points {
+		fmt.Fprintf(k.w, "<gx:coord>%s %s 0</gx:coord>\n", formatFloat(p.Lon), formatFloat(p.Lat))
+	}
+	k.w.WriteString(`<ExtendedData><SchemaData schemaUrl="#route"><gx:SimpleArrayData name="speed">` + "\n")
+	for _, p := range points {
+		// Every point needs a value, empty when the speed is unknown
+		var speed string
+		if p.Speed != nil {
+			speed = formatFloat(*p.Speed)
+		}
+		fmt.Fprintf(k.w, "<gx:value>%s</gx:value>\n", speed)
+	}
+	_, err := k.w.WriteString("</gx:SimpleArrayData></SchemaData></ExtendedData>\n</gx:Track>\n")
+	return err
+}
+
+func (k *kmlRoute) end() error {
+	_, err := k.w.WriteString("</gx:MultiTrack>\n</Placemark>\n</Document>\n</kml>\n")
+	return err
+}
+
+// csvRoute writes a header and a row per point; speed is empty when
+// unknown.
+type csvRoute struct {
+	w    *csv.Writer
+	meta routeMeta
+}
+
+func newCSVRoute(w *bufio.Writer, meta routeMeta) routeWriter {
+	return &csvRoute{w: csv.NewWriter(w), meta: meta}
+}
+
+func (c *csvRoute) begin() error {
+	c.w.Write([]string{"container_id", "timestamp", "lat", "lon", "speed"})
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvRoute) page(points []RoutePoint) error {
+	for _, p := range points {
+		var speed string
+		if p.Speed != nil {
+			speed = formatFloat(*p.Speed)
+		}
+		c.w.Write([]string{c.meta.ContainerID, formatTime(p.Timestamp), formatFloat(p.Lat), formatFloat(p.Lon), speed})
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvRoute) end() error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	consumer "github.com/lai/logistics/consumer/db"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exportMeta has a container ID needing XML escaping.
+var exportMeta = routeMeta{
+	ContainerID: `MSCU<1&"2">`,
+	From:        time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	To:          time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+}
+
+// exportPages are two pages of points, with and without speed.
+func exportPages() [][]RoutePoint {
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+	return [][]RoutePoint{
+		{
+			{Lat: 51.9225, Lon: 4.47917, Timestamp: at("2026-01-01T08:00:00Z"), Speed: ptr(1.5)},
+			{Lat: 51.93, Lon: 4.5, Timestamp: at("2026-01-01T08:00:30.123456Z")},
+		},
+		{
+			{Lat: -33.8688, Lon: 151.2093, Timestamp: at("2026-01-01T09:00:00Z"), Speed: ptr(0.0)},
+		},
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestRouteWriters_Golden(t *testing.T) {
+	for name, format := range exportFormats {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			bw := bufio.NewWriter(&buf)
+			rw := format.newWriter(bw, exportMeta)
+			if err := rw.begin(); err != nil {
+				t.Fatal(err)
+			}
+			for _, page := range exportPages() {
+				if err := rw.page(page); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := rw.end(); err != nil {
+				t.Fatal(err)
+			}
+			if err := bw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "route."+format.ext)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("output differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestAPIHandler_Export(t *testing.T) {
+	q := &fakeQuerier{route: []consumer.GetContainerRouteRow{
+		routeRow(apiNow.Add(-2*time.Hour), 51.0),
+		routeRow(apiNow.Add(-time.Hour), 51.1),
+	}}
+	h := newTestAPI(q)
+
+	rec := get(t, h, "/api/containers/MSCU%3C1%26%222%22%3E/export?format=csv", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	wantDisposition := `attachment; filename=MSCU_1__2___20251226T000000Z_20260102T000000Z.csv`
+	if cd := rec.Header().Get("Content-Disposition"); cd != wantDisposition {
+		t.Errorf("got Content-Disposition %q, want %q", cd, wantDisposition)
+	}
+	want := "container_id,timestamp,lat,lon,speed\n" +
+		`"MSCU<1&""2"">",2026-01-01T22:00:00Z,51,4.4,` + "\n" +
+		`"MSCU<1&""2"">",2026-01-01T23:00:00Z,51.1,4.4,` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got body\n%s\nwant\n%s", got, want)
+	}
+
+	if rec := get(t, h, "/api/containers/C1/export?format=shp", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown format, want 400", rec.Code)
+	}
+	q.err = errors.New("db down")
+	if rec := get(t, h, "/api/containers/C1/export", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d for a failing first page, want 503", rec.Code)
+	}
+}
+
+// failingRoute serves the first page of route, then fails.
+type failingRoute struct {
+	*fakeQuerier
+	calls int
+}
+
+func (f *failingRoute) GetContainerRoute(ctx context.Context, arg consumer.GetContainerRouteParams) ([]consumer.GetContainerRouteRow, error) {
+	f.calls++
+	if f.calls > 1 {
+		return nil, errors.New("db down")
+	}
+	return f.fakeQuerier.GetContainerRoute(ctx, arg)
+}
+
+func TestAPIHandler_ExportAbortsMidway(t *testing.T) {
+	from := apiNow.Add(-time.Hour)
+	var route []consumer.GetContainerRouteRow
+	for i := range routePageSize + 1 {
+		route = append(route, routeRow(from.Add(time.Duration(i)*time.Millisecond), float64(i)))
+	}
+	h := newTestAPI(&failingRoute{fakeQuerier: &fakeQuerier{route: route}})
+	rec := httptest.NewRecorder()
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatalf("got panic %v, want http.ErrAbortHandler", r)
+		}
+		// The first page went out, the end of the file did not
+		body := rec.Body.String()
+		if !strings.Contains(body, "<gpx") || strings.Contains(body, "</gpx>") {
+			t.Errorf("got body ending %q", body[max(0, len(body)-100):])
+		}
+	}()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/containers/C1/export?format=gpx", nil))
+}
+
+func TestEachRoutePage_SharedTimestampAtPageBoundary(t *testing.T) {
+	from := apiNow.Add(-time.Hour)
+	// The last point of the first page shares its timestamp with the
+	// first two of the second
+	var route []consumer.GetContainerRouteRow
+	for i := range routePageSize + 2 {
+		ts := from.Add(time.Duration(min(i, routePageSize-1)) * time.Millisecond)
+		route = append(route, routeRow(ts, float64(i)))
+	}
+
+	var pages int
+	var got []float64
+	err := eachRoutePage(context.Background(), &fakeQuerier{route: route}, "C1", from, apiNow, func(rows []consumer.GetContainerRouteRow) error {
+		pages++
+		for _, row := range rows {
+			got = append(got, row.Lat)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("got %d pages, want 2", pages)
+	}
+	if len(got) != len(route) {
+		t.Fatalf("got %d points, want %d", len(got), len(route))
+	}
+	for i, lat := range got {
+		if lat != float64(i) {
+			t.Fatalf("point %d has lat %v, want %v", i, lat, float64(i))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// GeoJSON (RFC 7946) types for the query API. Coordinates are [lon, lat].
+
+type featureCollection struct {
+	Type     string    `json:"type"` // "FeatureCollection"
+	Features []feature `json:"features"`
+	// Foreign member carrying the paging cursor
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type feature struct {
+	Type       string         `json:"type"` // "Feature"
+	Geometry   *geometry      `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geometry struct {
+	Type        string `json:"type"` // "Point" or "LineString"
+	Coordinates any    `json:"coordinates"`
+}
+
+func writeGeoJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+func pointGeometry(lat, lon float64) *geometry {
+	return &geometry{Type: "Point", Coordinates: [2]float64{lon, lat}}
+}
+
+func positionProperties(p Position) map[string]any {
+	props := map[string]any{
+		"container_id": p.ContainerID,
+		"timestamp":    p.Timestamp,
+	}
+	if p.Speed != nil {
+		props["speed"] = *p.Speed
+	}
+	return props
+}
+
+// positionsFeatures turns a page of positions into one Point per container.
+func positionsFeatures(page positionsPage) featureCollection {
+	fc := featureCollection{
+		Type:       "FeatureCollection",
+		Features:   make([]feature, len(page.Positions)),
+		NextCursor: page.NextCursor,
+	}
+	for i, p := range page.Positions {
+		fc.Features[i] = feature{
+			Type:       "Feature",
+			Geometry:   pointGeometry(p.Lat, p.Lon),
+			Properties: positionProperties(p),
+		}
+	}
+	return fc
+}
+
+// routeFeatures turns a page of a route into a LineString, with the time
+// and speed of every vertex in the coord_times and speeds properties. A
+// page with a single point becomes a Point, an empty page no feature.
+func routeFeatures(page routePage) featureCollection {
+	fc := featureCollection{
+		Type:       "FeatureCollection",
+		Features:   []feature{},
+		NextCursor: page.NextCursor,
+	}
+	if len(page.Points) > 0 {
+		fc.Features = append(fc.Features, routeFeature(page))
+	}
+	return fc
+}
+
+// routeFeature is the feature of routeFeatures for a non-empty page.
+func routeFeature(page routePage) feature {
+	coords := make([][2]float64, len(page.Points))
+	times := make([]time.Time, len(page.Points))
+	speeds := make([]*float64, len(page.Points))
+	for i, p := range page.Points {
+		coords[i] = [2]float64{p.Lon, p.Lat}
+		times[i] = p.Timestamp
+		speeds[i] = p.Speed
+	}
+	geom := &geometry{Type: "LineString", Coordinates: coords}
+	if len(coords) == 1 {
+		geom = &geometry{Type: "Point", Coordinates: coords[0]}
+	}
+	f := feature{
+		Type:     "Feature",
+		Geometry: geom,
+		Properties: map[string]any{
+			"container_id": page.ContainerID,
+			"from":         page.From,
+			"to":           page.To,
+			"coord_times":  times,
+			"speeds":       speeds,
+		},
+	}
+	if page.Resolution != "" {
+		f.Properties["resolution"] = page.Resolution
+		f.Properties["source_points"] = page.SourcePoints
+	}
+	return f
+}
+
+// containerFeature places a container at its latest fix, or nowhere (a null
+// geometry) when it never reported one.
+func containerFeature(d ContainerDetails) feature {
+	f := feature{
+		Type: "Feature",
+		Properties: map[string]any{
+			"container_id": d.ContainerID,
+		},
+	}
+	if d.Owner != "" {
+		f.Properties["owner"] = d.Owner
+	}
+	if d.ContainerType != "" {
+		f.Properties["container_type"] = d.ContainerType
+	}
+	if d.RegisteredAt != nil {
+		f.Properties["registered_at"] = *d.RegisteredAt
+	}
+	if p := d.LastPosition; p != nil {
+		f.Geometry = pointGeometry(p.Lat, p.Lon)
+		f.Properties["timestamp"] = p.Timestamp
+		if p.Speed != nil {
+			f.Properties["speed"] = *p.Speed
+		}
+	}
+	return f
+}
@@ -1,15 +1,29 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	consumer "github.com/lai/logistics/consumer/db"
 	"golang.org/x/net/websocket"
 )
 
+const (
+	// Longest route backfill a client may ask for with ?history=
+	maxHistoryHours = maxQueryDays * 24
+	// Backfilled routes are simplified to this many points for the map
+	maxHistoryPoints = 1000
+	snapshotTimeout  = 5 * time.Second
+)
+
 // WSMessage matches frontend/src/types/index.ts
 type WSMessage struct {
 	Type    string      `json:"type"` // "position", "route", "error"
@@ -17,6 +31,23 @@ type WSMessage struct {
 	Message string      `json:"message,omitempty"`
 }
 
+// Route matches the Route type of frontend/src/types/index.ts. Stops aren't
+// tracked, so a backfilled route has none and no destination.
+type Route struct {
+	ContainerID string       `json:"container_id"`
+	Path        [][2]float64 `json:"path"` // [lon, lat]
+	Stops       []StopPoint  `json:"stops"`
+	Destination *StopPoint   `json:"destination,omitempty"`
+}
+
+// StopPoint matches frontend/src/types/index.ts
+type StopPoint struct {
+	Name          string     `json:"name"`
+	Coordinates   [2]float64 `json:"coordinates"` // [lon, lat]
+	Sequence      int        `json:"sequence"`
+	IsDestination bool       `json:"isDestination,omitempty"`
+}
+
 // Client represents a connected WebSocket client
 type Client struct {
 	conn        *websocket.Conn
@@ -26,19 +57,26 @@ type Client struct {
 
 // Hub manages WebSocket clients grouped by containerID
 type Hub struct {
+	q consumer.Querier // for the snapshot sent on subscribe
+
 	mu      sync.RWMutex
 	clients map[string]map[*Client]bool // containerID -> set of clients
 }
 
-// NewHub creates a new WebSocket hub
-func NewHub() *Hub {
+// NewHub creates a new WebSocket hub reading snapshots through q
+func NewHub(q consumer.Querier) *Hub {
 	return &Hub{
+		q:       q,
 		clients: make(map[string]map[*Client]bool),
 	}
 }
 
 // ServeWS handles WebSocket upgrade and client lifecycle
-// URL: /api/track/{containerId}?token={authToken}
+// URL: /api/track/{containerId}?token={authToken}&history={hours}
+//
+// On subscribe the client first gets the container's last known position,
+// and with history a route message with the last hours of its track, so a
+// parked container doesn't show an empty map until its next point.
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	// Extract containerId from path: /api/track/MSCU1234567
 	path := strings.TrimPrefix(r.URL.Path, "/api/track/")
@@ -55,6 +93,16 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var history time.Duration
+	if v := r.URL.Query().Get("history"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 0 || hours > maxHistoryHours {
+			http.Error(w, "history must be between 0 and "+strconv.Itoa(maxHistoryHours)+" hours", http.StatusBadRequest)
+			return
+		}
+		history = time.Duration(hours) * time.Hour
+	}
+
 	// Upgrade to WebSocket
 	wsHandler := websocket.Handler(func(conn *websocket.Conn) {
 		client := &Client{
@@ -63,6 +111,16 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 			send:        make(chan []byte, 256),
 		}
 
+		// Written before the client is registered, so a snapshot never
+		// overwrites a newer broadcast position. A batch stored in between
+		// is missed, but the container's next one arrives within seconds.
+		if err := h.sendSnapshot(conn, containerID, history); err != nil {
+			slog.Error("websocket snapshot failed", "container_id", containerID, "error", err)
+			if errors.Is(err, errSnapshotWrite) {
+				return
+			}
+		}
+
 		h.register(client)
 		defer h.unregister(client)
 
@@ -92,6 +150,83 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	wsHandler.ServeHTTP(w, r)
 }
 
+var errSnapshotWrite = errors.New("write snapshot")
+
+// sendSnapshot writes the last known position of containerID to conn, then
+// its route over the last history, if any, from the hourly summary when the
+// history has more than maxRawSimplifiedPoints points. A container that never
+// reported gets nothing. Query errors are returned but leave the connection usable;
+// write errors are wrapped in errSnapshotWrite.
+func (h *Hub) sendSnapshot(conn *websocket.Conn, containerID string, history time.Duration) error {
+	ctx, cancel := context.WithTimeout(conn.Request().Context(), snapshotTimeout)
+	defer cancel()
+
+	pos, err := h.q.GetLatestPosition(ctx, containerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeWS(conn, WSMessage{Type: "position", Data: latestTrackPoint(containerID, pos)}); err != nil {
+		return err
+	}
+	if history == 0 {
+		return nil
+	}
+
+	// Up to the last position, so a parked container still gets the trip
+	// that brought it there
+	to := pos.Time.Time.Add(time.Microsecond)
+	points, err := rawRoute(ctx, h.q, containerID, to.Add(-history), to)
+	if errors.Is(err, errRawRouteTooLong) {
+		points, err = hourlyRoute(ctx, h.q, containerID, to.Add(-history), to)
+	}
+	if err != nil {
+		return err
+	}
+	route := Route{ContainerID: containerID, Path: [][2]float64{}, Stops: []StopPoint{}}
+	for _, p := range visvalingam(points, maxHistoryPoints) {
+		route.Path = append(route.Path, [2]float64{p.Lon, p.Lat})
+	}
+	return writeWS(conn, WSMessage{Type: "route", Data: route})
+}
+
+// latestTrackPoint is the stored point pos as it was broadcast, so the
+// snapshot carries the same sensor readings as live position messages.
+func latestTrackPoint(containerID string, pos consumer.GetLatestPositionRow) TrackPoint {
+	tp := TrackPoint{
+		ContainerID: containerID,
+		Lat:         pos.Lat,
+		Lon:         pos.Lon,
+		Timestamp:   pos.Time.Time,
+		Speed:       pos.Speed.Float64,
+		PointID:     pos.PointID.String,
+		Heading:     optFloat(pos.Heading),
+		Altitude:    optFloat(pos.Altitude),
+		Accuracy:    optFloat(pos.Accuracy),
+		Battery:     optFloat(pos.Battery),
+		Temperature: optFloat(pos.Temperature),
+		Humidity:    optFloat(pos.Humidity),
+	}
+	if pos.DoorOpen.Valid {
+		tp.DoorOpen = &pos.DoorOpen.Bool
+	}
+	return tp
+}
+
+// writeWS writes msg to conn. Only for use before the write pump starts.
+func writeWS(conn *websocket.Conn, msg WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return errors.Join(errSnapshotWrite, err)
+	}
+	return nil
+}
+
 func (h *Hub) register(c *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -100,6 +235,7 @@ func (h *Hub) register(c *Client) {
 		h.clients[c.containerID] = make(map[*Client]bool)
 	}
 	h.clients[c.containerID][c] = true
+	wsClients.Inc()
 }
 
 func (h *Hub) unregister(c *Client) {
@@ -109,6 +245,7 @@ func (h *Hub) unregister(c *Client) {
 	if clients, ok := h.clients[c.containerID]; ok {
 		delete(clients, c)
 		close(c.send)
+		wsClients.Dec()
 		if len(clients) == 0 {
 			delete(h.clients, c.containerID)
 		}
@@ -148,5 +285,6 @@ func (h *Hub) CloseAll() {
 			client.conn.Close()
 		}
 	}
+	wsClients.Set(0)
 	h.clients = make(map[string]map[*Client]bool)
 }
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	consumer "github.com/lai/logistics/consumer/db"
+	"golang.org/x/net/websocket"
+)
+
+// dialHub subscribes to containerID on a test server running h.
+func dialHub(t *testing.T, h *Hub, containerID, query string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(h.ServeWS))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/track/" + containerID + "?token=test" + query
+	conn, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func receive(t *testing.T, conn *websocket.Conn) []byte {
+	t.Helper()
+	var data []byte
+	if err := websocket.Message.Receive(conn, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// waitRegistered waits until containerID has a subscriber, so a broadcast
+// reaches it.
+func waitRegistered(t *testing.T, h *Hub, containerID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.RLock()
+		n := len(h.clients[containerID])
+		h.mu.RUnlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client was not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHub_SnapshotMatchesBroadcast(t *testing.T) {
+	at := time.Date(2026, 1, 1, 8, 0, 30, 123456000, time.UTC)
+	tp := TrackPoint{
+		ContainerID: "CSQU3054383",
+		Lat:         51.9,
+		Lon:         4.4,
+		Timestamp:   at,
+		Speed:       3.5,
+		PointID:     "p-1",
+		Heading:     ptr(90.0),
+		Altitude:    ptr(2.0),
+		Accuracy:    ptr(5.0),
+		Battery:     ptr(80.0),
+		Temperature: ptr(-18.5),
+		Humidity:    ptr(40.0),
+		DoorOpen:    ptr(false),
+	}
+	float8 := func(v float64) pgtype.Float8 { return pgtype.Float8{Float64: v, Valid: true} }
+	q := &fakeQuerier{
+		latest: map[string]consumer.GetLatestPositionRow{
+			tp.ContainerID: {
+				Time:        timestamptz(at),
+				Lat:         tp.Lat,
+				Lon:         tp.Lon,
+				Speed:       float8(tp.Speed),
+				PointID:     pgtype.Text{String: tp.PointID, Valid: true},
+				Heading:     float8(*tp.Heading),
+				Altitude:    float8(*tp.Altitude),
+				Accuracy:    float8(*tp.Accuracy),
+				Battery:     float8(*tp.Battery),
+				Temperature: float8(*tp.Temperature),
+				Humidity:    float8(*tp.Humidity),
+				DoorOpen:    pgtype.Bool{Bool: false, Valid: true},
+			},
+		},
+		route: []consumer.GetContainerRouteRow{
+			routeRow(at.Add(-3*time.Hour), 51.0), // before the history
+			routeRow(at.Add(-time.Hour), 51.5),
+			{Time: timestamptz(at), Lat: tp.Lat, Lon: tp.Lon},
+		},
+	}
+	h := NewHub(q)
+	conn := dialHub(t, h, tp.ContainerID, "&history=2")
+
+	snapshot := receive(t, conn)
+	var route struct {
+		Type string
+		Data Route
+	}
+	if err := json.Unmarshal(receive(t, conn), &route); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]float64{{4.4, 51.5}, {4.4, 51.9}}; route.Type != "route" || len(route.Data.Path) != 2 || route.Data.Path[0] != want[0] || route.Data.Path[1] != want[1] {
+		t.Errorf("got route %+v, want path %v", route, want)
+	}
+
+	waitRegistered(t, h, tp.ContainerID)
+	h.Broadcast(tp.ContainerID, WSMessage{Type: "position", Data: tp})
+	if live := receive(t, conn); string(snapshot) != string(live) {
+		t.Errorf("snapshot differs from the broadcast of the same point:\n%s\n%s", snapshot, live)
+	}
+}
+
+func TestHub_HistoryFallsBackToHourly(t *testing.T) {
+	at := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	// More raw points in the last hour than are read into memory
+	var many []consumer.GetContainerRouteRow
+	for i := range maxRawSimplifiedPoints + 1 {
+		many = append(many, routeRow(at.Add(-time.Hour+time.Duration(i)*time.Microsecond), 51))
+	}
+	q := &fakeQuerier{
+		latest: map[string]consumer.GetLatestPositionRow{"C001": {Time: timestamptz(at), Lat: 51.9, Lon: 4.4}},
+		route:  many,
+		hourly: []consumer.GetContainerRouteHourlyRow{{Time: timestamptz(at.Add(-time.Hour)), Lat: 51.5, Lon: 4.4}},
+	}
+	conn := dialHub(t, NewHub(q), "C001", "&history=2")
+
+	receive(t, conn) // position
+	var route struct {
+		Type string
+		Data Route
+	}
+	if err := json.Unmarshal(receive(t, conn), &route); err != nil {
+		t.Fatal(err)
+	}
+	if want := [2]float64{4.4, 51.5}; route.Type != "route" || len(route.Data.Path) != 1 || route.Data.Path[0] != want {
+		t.Errorf("got route %+v, want the hourly path [%v]", route, want)
+	}
+}
+
+func TestHub_NoSnapshot(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *fakeQuerier
+	}{
+		{"never reported", &fakeQuerier{}},
+		{"query failed", &fakeQuerier{err: errors.New("db down")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(tt.q)
+			conn := dialHub(t, h, "C001", "&history=1")
+
+			// Still subscribed, and the broadcast is the first message
+			waitRegistered(t, h, "C001")
+			h.Broadcast("C001", WSMessage{Type: "position", Data: TrackPoint{ContainerID: "C001"}})
+			var msg WSMessage
+			if err := json.Unmarshal(receive(t, conn), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != "position" {
+				t.Errorf("got %+v, want the broadcast position", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	insertDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "consumer_db_insert_duration_seconds",
+		Help:    "Time to COPY a batch of track points into TimescaleDB.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms to ~8s
+	})
+
+	pointsInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_points_inserted_total",
+		Help: "Track points inserted, including ones the dedup key dropped.",
+	})
+
+	insertErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_db_insert_errors_total",
+		Help: "Failed batch inserts.",
+	})
+
+	wsClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consumer_websocket_clients",
+		Help: "Connected WebSocket clients.",
+	})
+)
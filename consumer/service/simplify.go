@@ -0,0 +1,169 @@
+package service
+
+import (
+	"container/heap"
+	"math"
+)
+
+const earthRadiusMeters = 6371000
+
+// planar is a point in meters on a plane tangent to the globe.
+type planar struct {
+	x, y float64
+}
+
+// project places p on the equirectangular plane centered on origin, taking
+// the short way across the antimeridian. Far from origin it distorts, which
+// only shifts which points simplification keeps, never where they are.
+func project(p, origin RoutePoint) planar {
+	const rad = math.Pi / 180
+	dLon := p.Lon - origin.Lon
+	if dLon > 180 {
+		dLon -= 360
+	} else if dLon < -180 {
+		dLon += 360
+	}
+	return planar{
+		x: dLon * rad * earthRadiusMeters * math.Cos(origin.Lat*rad),
+		y: (p.Lat - origin.Lat) * rad * earthRadiusMeters,
+	}
+}
+
+// segmentDistance is the distance in meters from p to the segment a-b.
+// Measuring to the segment rather than the line through it keeps a route
+// that doubles back, like a return trip, from collapsing.
+func segmentDistance(p, a, b RoutePoint) float64 {
+	pp, bb := project(p, a), project(b, a)
+	t := 0.0
+	if l2 := bb.x*bb.x + bb.y*bb.y; l2 > 0 {
+		t = min(max((pp.x*bb.x+pp.y*bb.y)/l2, 0), 1)
+	}
+	return math.Hypot(pp.x-t*bb.x, pp.y-t*bb.y)
+}
+
+// triangleArea is the area in m² of the triangle a-b-c.
+func triangleArea(a, b, c RoutePoint) float64 {
+	bb, cc := project(b, a), project(c, a)
+	return math.Abs(bb.x*cc.y-cc.x*bb.y) / 2
+}
+
+// douglasPeucker drops the points that are within tolerance meters of the
+// simplified line. The first and last point are always kept.
+func douglasPeucker(points []RoutePoint, tolerance float64) []RoutePoint {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	// Iterative, as a month of points would recurse too deep
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		farthest, dist := 0, 0.0
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > dist {
+				farthest, dist = i, d
+			}
+		}
+		if dist > tolerance {
+			keep[farthest] = true
+			stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	out := make([]RoutePoint, 0)
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// visvalingam repeatedly drops the point that spans the smallest triangle
+// with its neighbours until at most maxPoints are left. Unlike
+// douglasPeucker it can aim for a point count, which is what a map
+// rendering a polyline cares about. The first and last point are always
+// kept, so maxPoints below 2 acts as 2.
+func visvalingam(points []RoutePoint, maxPoints int) []RoutePoint {
+	n := len(points)
+	if n <= max(maxPoints, 2) {
+		return points
+	}
+	prev, next := make([]int, n), make([]int, n)
+	vertices := make([]*vertex, n)
+	h := make(areaHeap, 0, n-2)
+	for i := range points {
+		prev[i], next[i] = i-1, i+1
+		if i > 0 && i < n-1 {
+			vertices[i] = &vertex{i: i, area: triangleArea(points[i-1], points[i], points[i+1]), index: len(h)}
+			h = append(h, vertices[i])
+		}
+	}
+	heap.Init(&h)
+
+	removed := make([]bool, n)
+	for left := n; left > max(maxPoints, 2); left-- {
+		v := heap.Pop(&h).(*vertex)
+		removed[v.i] = true
+		p, nx := prev[v.i], next[v.i]
+		next[p], prev[nx] = nx, p
+		for _, j := range [2]int{p, nx} {
+			if j == 0 || j == n-1 {
+				continue
+			}
+			// Never below the area just removed, so a point doesn't jump
+			// ahead of one that was dropped before it
+			vertices[j].area = max(triangleArea(points[prev[j]], points[j], points[next[j]]), v.area)
+			heap.Fix(&h, vertices[j].index)
+		}
+	}
+
+	out := make([]RoutePoint, 0, max(maxPoints, 2))
+	for i, p := range points {
+		if !removed[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// vertex is a point of visvalingam with the area of its triangle.
+type vertex struct {
+	i     int // index in the route
+	area  float64
+	index int // index in the heap
+}
+
+// areaHeap is a min-heap of vertices by area, earlier points first on ties.
+type areaHeap []*vertex
+
+func (h areaHeap) Len() int { return len(h) }
+
+func (h areaHeap) Less(a, b int) bool {
+	if h[a].area != h[b].area {
+		return h[a].area < h[b].area
+	}
+	return h[a].i < h[b].i
+}
+
+func (h areaHeap) Swap(a, b int) {
+	h[a], h[b] = h[b], h[a]
+	h[a].index = a
+	h[b].index = b
+}
+
+func (h *areaHeap) Push(x any) {
+	v := x.(*vertex)
+	v.index = len(*h)
+	*h = append(*h, v)
+}
+
+func (h *areaHeap) Pop() any {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
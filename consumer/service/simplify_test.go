@@ -0,0 +1,122 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// route builds a route from lat/lon pairs, one second apart.
+func route(coords ...[2]float64) []RoutePoint {
+	points := make([]RoutePoint, len(coords))
+	for i, c := range coords {
+		points[i] = RoutePoint{Lat: c[0], Lon: c[1], Timestamp: apiNow.Add(time.Duration(i) * time.Second)}
+	}
+	return points
+}
+
+// kept returns the index in the route of each point of out.
+func kept(out []RoutePoint) []int {
+	indexes := make([]int, len(out))
+	for i, p := range out {
+		indexes[i] = int(p.Timestamp.Sub(apiNow) / time.Second)
+	}
+	return indexes
+}
+
+// 0.001° is about 111 m
+func TestDouglasPeucker(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []RoutePoint
+		want   []int
+	}{
+		{"empty", route(), []int{}},
+		{"one point", route([2]float64{51, 4}), []int{0}},
+		{"two points", route([2]float64{51, 4}, [2]float64{52, 5}), []int{0, 1}},
+		{"collinear", route([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0, 0.002}, [2]float64{0, 0.003}), []int{0, 3}},
+		{"duplicates", route([2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0}), []int{0, 2}},
+		{"spike", route([2]float64{0, 0}, [2]float64{0.001, 0.001}, [2]float64{0, 0.002}), []int{0, 1, 2}},
+		{"wiggle within tolerance", route([2]float64{0, 0}, [2]float64{0.00005, 0.001}, [2]float64{0, 0.002}), []int{0, 2}},
+		{"return trip", route([2]float64{0, 0}, [2]float64{0, 0.002}, [2]float64{0, 0}), []int{0, 1, 2}},
+		{"across the antimeridian", route([2]float64{0, 179.999}, [2]float64{0, -180}, [2]float64{0, -179.999}), []int{0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := kept(douglasPeucker(tt.points, 10))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisvalingam(t *testing.T) {
+	// A shallow zigzag with one far peak
+	zigzag := route([2]float64{0, 0}, [2]float64{0.0001, 0.001}, [2]float64{0.01, 0.002}, [2]float64{0.0001, 0.003}, [2]float64{0, 0.004})
+
+	tests := []struct {
+		name      string
+		points    []RoutePoint
+		maxPoints int
+		want      []int
+	}{
+		{"empty", route(), 2, []int{}},
+		{"one point", route([2]float64{51, 4}), 2, []int{0}},
+		{"two points", route([2]float64{51, 4}, [2]float64{52, 5}), 2, []int{0, 1}},
+		{"below target", zigzag, 5, []int{0, 1, 2, 3, 4}},
+		{"target below two", zigzag, 0, []int{0, 4}},
+		{"keeps the peak", zigzag, 3, []int{0, 2, 4}},
+		{"collinear", route([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0, 0.002}, [2]float64{0, 0.003}, [2]float64{0, 0.004}), 3, []int{0, 3, 4}},
+		{"duplicates", route([2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0.001}), 2, []int{0, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := kept(visvalingam(tt.points, tt.maxPoints))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplify_MeetsTarget(t *testing.T) {
+	// A winding route of a thousand points
+	coords := make([][2]float64, 1000)
+	for i := range coords {
+		coords[i] = [2]float64{51 + 0.01*math.Sin(float64(i)/7), 4 + 0.0005*float64(i)}
+	}
+	points := route(coords...)
+
+	for _, maxPoints := range []int{2, 3, 100, 999} {
+		got := kept(visvalingam(points, maxPoints))
+		if len(got) != maxPoints {
+			t.Errorf("max_points %d: kept %d points", maxPoints, len(got))
+		}
+		if got[0] != 0 || got[len(got)-1] != len(points)-1 {
+			t.Errorf("max_points %d: endpoints not kept: %d..%d", maxPoints, got[0], got[len(got)-1])
+		}
+		if !slices.IsSorted(got) {
+			t.Errorf("max_points %d: points out of order", maxPoints)
+		}
+	}
+
+	// Douglas-Peucker keeps every point that is off the line by more than
+	// the tolerance
+	got := douglasPeucker(points, 50)
+	if len(got) >= len(points) || len(got) < 3 {
+		t.Fatalf("kept %d of %d points", len(got), len(points))
+	}
+	for _, p := range points {
+		d := math.Inf(1)
+		for i := 1; i < len(got); i++ {
+			d = min(d, segmentDistance(p, got[i-1], got[i]))
+		}
+		if d > 50 {
+			t.Fatalf("point at %v is %.1f m off the simplified route", p.Timestamp, d)
+		}
+	}
+}
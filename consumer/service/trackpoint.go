@@ -6,16 +6,12 @@ import (
 
 	"github.com/jackc/pgx/v5/pgtype"
 	consumer "github.com/lai/logistics/consumer/db"
+	"github.com/lai/logistics/pkg/contracts"
 )
 
-// TrackPoint matches the Kafka message format from telemetry service
-type TrackPoint struct {
-	ContainerID string    `json:"container_id"`
-	Lat         float64   `json:"lat"`
-	Lon         float64   `json:"lon"`
-	Timestamp   time.Time `json:"timestamp"`
-	Speed       float64   `json:"speed"`
-}
+// TrackPoint is the message format of container.telemetry, shared with
+// the telemetry service.
+type TrackPoint = contracts.TrackPoint
 
 // BulkInsert converts TrackPoints to sqlc params and inserts via CopyFrom
 func BulkInsert(ctx context.Context, q *consumer.Queries, points []TrackPoint) error {
@@ -27,7 +23,32 @@ func BulkInsert(ctx context.Context, q *consumer.Queries, points []TrackPoint) e
 			Lat:         p.Lat,
 			Lon:         p.Lon,
 			Speed:       pgtype.Float8{Float64: p.Speed, Valid: true},
+			PointID:     pgtype.Text{String: p.PointID, Valid: p.PointID != ""},
+			Heading:     float8(p.Heading),
+			Altitude:    float8(p.Altitude),
+			Accuracy:    float8(p.Accuracy),
+			Battery:     float8(p.Battery),
+			Temperature: float8(p.Temperature),
+			Humidity:    float8(p.Humidity),
+		}
+		if p.DoorOpen != nil {
+			dbPoints[i].DoorOpen = pgtype.Bool{Bool: *p.DoorOpen, Valid: true}
 		}
 	}
-	return q.BulkInsertTrackPoints(ctx, dbPoints)
+	start := time.Now()
+	if err := q.BulkInsertTrackPoints(ctx, dbPoints); err != nil {
+		insertErrors.Inc()
+		return err
+	}
+	insertDuration.Observe(time.Since(start).Seconds())
+	pointsInserted.Add(float64(len(points)))
+	return nil
+}
+
+// float8 maps an optional reading to a nullable column.
+func float8(v *float64) pgtype.Float8 {
+	if v == nil {
+		return pgtype.Float8{}
+	}
+	return pgtype.Float8{Float64: *v, Valid: true}
 }
@@ -3,6 +3,7 @@ import { useStore } from '../stores';
 import type { WSMessage } from '../types';
 
 const WS_URL = import.meta.env.VITE_WS_URL || 'wss://logistics.example.com';
+const HISTORY_HOURS = import.meta.env.VITE_WS_HISTORY_HOURS || '24';
 const RECONNECT_DELAY = 3000;
 
 export function useWebSocket(containerId: string) {
@@ -21,7 +22,8 @@ export function useWebSocket(containerId: string) {
       wsRef.current.close();
     }
 
-    const url = `${WS_URL}/api/track/${containerId}?token=${auth.token}`;
+    // 连接后服务端先推送最后位置和 history 小时内的航线
+    const url = `${WS_URL}/api/track/${containerId}?token=${auth.token}&history=${HISTORY_HOURS}`;
     const ws = new WebSocket(url);
     wsRef.current = ws;
 
@@ -5,6 +5,15 @@ export interface TrackPoint {
   lon: number;
   timestamp: string; // RFC3339
   speed: number;
+  point_id?: string;
+  // 可选传感器读数，设备未上报时不存在
+  heading?: number;
+  altitude?: number;
+  accuracy?: number;
+  battery?: number;
+  temperature?: number;
+  humidity?: number;
+  door_open?: boolean;
 }
 
 // GeoJSON 标准格式，坐标是 [lon, lat]
@@ -21,7 +30,8 @@ export interface Route {
   container_id: string;
   path: Position[];
   stops: StopPoint[];
-  destination: StopPoint;
+  // 后端回放的历史航线没有终点
+  destination?: StopPoint;
 }
 
 // WebSocket 消息类型
@@ -2,6 +2,7 @@
 
 interface ImportMetaEnv {
   readonly VITE_WS_URL: string;
+  readonly VITE_WS_HISTORY_HOURS?: string;
   readonly VITE_KEYCLOAK_URL?: string;
   readonly VITE_KEYCLOAK_REALM?: string;
   readonly VITE_KEYCLOAK_CLIENT_ID?: string;
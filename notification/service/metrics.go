@@ -0,0 +1,17 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// One email goes to all recipients of an event
+	emailResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_emails_total",
+		Help: "Alert emails by result (sent or failed).",
+	}, []string{"result"})
+
+	emailsSent   = emailResults.WithLabelValues("sent")
+	emailsFailed = emailResults.WithLabelValues("failed")
+)
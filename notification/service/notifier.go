@@ -2,42 +2,109 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/lai/logistics/notification/db"
+	"github.com/lai/logistics/pkg/kafkaconsumer"
+	"github.com/lai/logistics/pkg/tracing"
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// How long a sent event is remembered; covers the retries of its batch.
+const sentTTL = time.Hour
+
+var tracer = otel.Tracer("github.com/lai/logistics/notification/service")
+
 type Notifier struct {
 	queries      *db.Queries
 	smtpHost     string
 	smtpPort     int
 	smtpUser     string
 	smtpPassword string
+	// handle emails one event; handleEvent unless replaced in tests.
+	handle func(context.Context, GeofenceEvent) error
+	now    func() time.Time
+
+	mu   sync.Mutex
+	sent map[GeofenceEvent]time.Time // events already emailed, for batch retries
 }
 
 func NewNotifier(queries *db.Queries, smtpHost string, smtpPort int, smtpUser, smtpPassword string) *Notifier {
-	return &Notifier{
+	n := &Notifier{
 		queries:      queries,
 		smtpHost:     smtpHost,
 		smtpPort:     smtpPort,
 		smtpUser:     smtpUser,
 		smtpPassword: smtpPassword,
+		now:          time.Now,
+		sent:         make(map[GeofenceEvent]time.Time),
 	}
+	n.handle = n.handleEvent
+	return n
 }
 
-func (n *Notifier) HandleBatch(ctx context.Context, events []GeofenceEvent) {
-	for _, evt := range events {
-		if err := n.handleEvent(ctx, evt); err != nil {
+// HandleBatch emails every event of the batch and returns the failures.
+// When the batch is retried, events that were sent the first time are
+// skipped, so recipients don't get the same alert twice.
+//
+// Each event is handled in the trace of the point that caused it, when ctx
+// comes from the Kafka consumer.
+func (n *Notifier) HandleBatch(ctx context.Context, events []GeofenceEvent) error {
+	var errs []error
+	for i, evt := range events {
+		if n.wasSent(evt) {
+			continue
+		}
+		evtCtx, span := tracer.Start(kafkaconsumer.ItemContext(ctx, i), "notify",
+			trace.WithAttributes(
+				attribute.String("container.id", evt.ContainerID),
+				attribute.String("geofence.name", evt.GeofenceName),
+				attribute.String("geofence.event_type", evt.EventType),
+			),
+		)
+		err := n.handle(evtCtx, evt)
+		tracing.End(span, err)
+		if err != nil {
 			slog.Error("handle event failed",
 				"container_id", evt.ContainerID,
 				"geofence", evt.GeofenceName,
 				"error", err,
 			)
+			errs = append(errs, err)
+			continue
 		}
+		n.markSent(evt)
 	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) wasSent(evt GeofenceEvent) bool {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	at, ok := n.sent[evt]
+	return ok && now.Sub(at) <= sentTTL
+}
+
+func (n *Notifier) markSent(evt GeofenceEvent) {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for e, at := range n.sent {
+		if now.Sub(at) > sentTTL {
+			delete(n.sent, e)
+		}
+	}
+	n.sent[evt] = now
 }
 
 func (n *Notifier) handleEvent(ctx context.Context, evt GeofenceEvent) error {
@@ -76,10 +143,12 @@ func (n *Notifier) handleEvent(ctx context.Context, evt GeofenceEvent) error {
 	notifier := notify.New()
 	notifier.UseServices(mailSvc)
 
-	if err := notifier.Send(ctx, subject, body); err != nil {
+	if err := n.send(ctx, notifier, subject, body, len(emails)); err != nil {
 		slog.Error("send email failed", "error", err, "recipients", emails)
+		emailsFailed.Inc()
 		return fmt.Errorf("send email: %w", err)
 	}
+	emailsSent.Inc()
 
 	for _, r := range recipients {
 		if err := n.queries.RecordEmail(ctx, db.RecordEmailParams{
@@ -102,3 +171,18 @@ func (n *Notifier) handleEvent(ctx context.Context, evt GeofenceEvent) error {
 
 	return nil
 }
+
+// send delivers the email in a client span covering the SMTP exchange.
+func (n *Notifier) send(ctx context.Context, notifier *notify.Notify, subject, body string, recipients int) error {
+	ctx, span := tracer.Start(ctx, "smtp send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.ServerAddress(n.smtpHost),
+			semconv.ServerPort(n.smtpPort),
+			attribute.Int("email.recipients", recipients),
+		),
+	)
+	err := notifier.Send(ctx, subject, body)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeSender records the events emailed and fails the ones in fail.
+type fakeSender struct {
+	fail     map[string]bool // by container ID
+	attempts map[string]int
+}
+
+func (s *fakeSender) handle(_ context.Context, evt GeofenceEvent) error {
+	s.attempts[evt.ContainerID]++
+	if s.fail[evt.ContainerID] {
+		return errors.New("smtp unavailable")
+	}
+	return nil
+}
+
+func newTestNotifier(s *fakeSender) *Notifier {
+	n := NewNotifier(nil, "localhost", 25, "", "")
+	n.handle = s.handle
+	return n
+}
+
+func TestNotifier_RetrySkipsSentEvents(t *testing.T) {
+	s := &fakeSender{fail: map[string]bool{"TCLU7654321": true}, attempts: make(map[string]int)}
+	n := newTestNotifier(s)
+	batch := []GeofenceEvent{
+		{ContainerID: "CSQU3054383", GeofenceName: "Depot", EventType: "enter"},
+		{ContainerID: "TCLU7654321", GeofenceName: "Depot", EventType: "enter"},
+	}
+
+	if err := n.HandleBatch(context.Background(), batch); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The batch is retried: only the failed event is emailed again
+	s.fail = nil
+	if err := n.HandleBatch(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if s.attempts["CSQU3054383"] != 1 || s.attempts["TCLU7654321"] != 2 {
+		t.Errorf("got attempts %v, want CSQU3054383 once and TCLU7654321 twice", s.attempts)
+	}
+
+	// Both are sent now, so a further retry emails nothing
+	if err := n.HandleBatch(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if s.attempts["CSQU3054383"] != 1 || s.attempts["TCLU7654321"] != 2 {
+		t.Errorf("got attempts %v after all were sent", s.attempts)
+	}
+}
+
+func TestNotifier_SentEventsExpire(t *testing.T) {
+	s := &fakeSender{attempts: make(map[string]int)}
+	n := newTestNotifier(s)
+	now := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	evt := GeofenceEvent{ContainerID: "CSQU3054383", GeofenceName: "Depot", EventType: "enter"}
+	other := GeofenceEvent{ContainerID: "TCLU7654321", GeofenceName: "Depot", EventType: "exit"}
+
+	ctx := context.Background()
+	for _, step := range []struct {
+		after time.Duration
+		batch []GeofenceEvent
+		want  int // attempts for evt so far
+	}{
+		{0, []GeofenceEvent{evt}, 1},
+		{sentTTL, []GeofenceEvent{evt}, 1},     // still remembered
+		{time.Second, []GeofenceEvent{evt}, 2}, // forgotten, so sent again
+	} {
+		now = now.Add(step.after)
+		if err := n.HandleBatch(ctx, step.batch); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.attempts[evt.ContainerID]; got != step.want {
+			t.Fatalf("after %v: got %d attempts, want %d", step.after, got, step.want)
+		}
+	}
+
+	// Marking another event sent drops the expired entries
+	now = now.Add(sentTTL + time.Second)
+	if err := n.HandleBatch(ctx, []GeofenceEvent{other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.sent[evt]; ok || len(n.sent) != 1 {
+		t.Errorf("got %d remembered events, want only the last one", len(n.sent))
+	}
+}
@@ -0,0 +1,265 @@
+// Command dlq inspects a dead-letter topic and replays messages from it onto
+// their source topic.
+//
+//	dlq inspect -topic consumer-service.dlq -reason decode
+//	dlq replay -topic ruleengine-service.dlq -since 2026-03-01T00:00:00Z -dry-run
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lai/logistics/pkg/dlq"
+	"github.com/segmentio/kafka-go"
+)
+
+const usage = `usage: dlq <command> [flags]
+
+Commands:
+  inspect   print dead letters as JSON lines
+  replay    write dead letters back onto their source topic
+
+Run "dlq <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "inspect":
+		err = inspect(ctx, os.Args[2:])
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dlq:", err)
+		os.Exit(1)
+	}
+}
+
+// options are the flags shared by all commands.
+type options struct {
+	brokers []string
+	topic   string
+	filter  dlq.Filter
+	limit   int
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) (*options, error) {
+	brokers := fs.String("brokers", envOr("KAFKA_BROKERS", "localhost:9092"), "comma-separated broker list")
+	topic := fs.String("topic", "", "dead-letter topic to read (required)")
+	reason := fs.String("reason", "", "only messages with this reason: decode, unsupported_version or retries_exhausted")
+	sourceTopic := fs.String("source-topic", "", "only messages from this source topic")
+	key := fs.String("key", "", "only messages with this key (container ID)")
+	errContains := fs.String("error", "", "only messages whose error contains this text")
+	since := fs.String("since", "", "only messages dead-lettered at or after this RFC 3339 time")
+	until := fs.String("until", "", "only messages dead-lettered before this RFC 3339 time")
+	limit := fs.Int("limit", 0, "stop after this many matching messages, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *topic == "" {
+		return nil, errors.New("-topic is required")
+	}
+
+	opts := &options{
+		brokers: strings.Split(*brokers, ","),
+		topic:   *topic,
+		filter: dlq.Filter{
+			Reason:        dlq.Reason(*reason),
+			SourceTopic:   *sourceTopic,
+			Key:           *key,
+			ErrorContains: *errContains,
+		},
+		limit: *limit,
+	}
+	var err error
+	if opts.filter.Since, err = parseTime(*since); err != nil {
+		return nil, fmt.Errorf("-since: %w", err)
+	}
+	if opts.filter.Until, err = parseTime(*until); err != nil {
+		return nil, fmt.Errorf("-until: %w", err)
+	}
+	return opts, nil
+}
+
+// deadLetter is the JSON form of a dead letter printed by inspect.
+type deadLetter struct {
+	Partition       int    `json:"partition"`
+	Offset          int64  `json:"offset"`
+	Key             string `json:"key"`
+	Reason          string `json:"reason"`
+	Error           string `json:"error"`
+	Attempts        string `json:"attempts"`
+	ConsumerGroup   string `json:"consumer_group"`
+	SourceTopic     string `json:"source_topic"`
+	SourcePartition string `json:"source_partition"`
+	SourceOffset    string `json:"source_offset"`
+	Time            string `json:"time"`
+	Value           string `json:"value"`
+}
+
+func inspect(ctx context.Context, args []string) error {
+	opts, err := parseFlags(flag.NewFlagSet("inspect", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	return scan(ctx, opts, func(msg kafka.Message) error {
+		return enc.Encode(deadLetter{
+			Partition:       msg.Partition,
+			Offset:          msg.Offset,
+			Key:             string(msg.Key),
+			Reason:          dlq.Header(msg, dlq.HeaderReason),
+			Error:           dlq.Header(msg, dlq.HeaderError),
+			Attempts:        dlq.Header(msg, dlq.HeaderAttempts),
+			ConsumerGroup:   dlq.Header(msg, dlq.HeaderGroup),
+			SourceTopic:     dlq.Header(msg, dlq.HeaderTopic),
+			SourcePartition: dlq.Header(msg, dlq.HeaderPartition),
+			SourceOffset:    dlq.Header(msg, dlq.HeaderOffset),
+			Time:            dlq.Header(msg, dlq.HeaderTime),
+			Value:           string(msg.Value),
+		})
+	})
+}
+
+func replay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	to := fs.String("to", "", "write to this topic instead of each message's source topic")
+	dryRun := fs.Bool("dry-run", false, "print what would be replayed without writing")
+	opts, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(opts.brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	replayed := 0
+	err = scan(ctx, opts, func(msg kafka.Message) error {
+		topic, original := dlq.Restore(msg)
+		if *to != "" {
+			topic = *to
+		}
+		if topic == "" {
+			fmt.Fprintf(os.Stderr, "skipping %d/%d: no source topic\n", msg.Partition, msg.Offset)
+			return nil
+		}
+		fmt.Printf("%d/%d -> %s key=%s\n", msg.Partition, msg.Offset, topic, msg.Key)
+		if *dryRun {
+			return nil
+		}
+		original.Topic = topic
+		if err := writer.WriteMessages(ctx, original); err != nil {
+			return fmt.Errorf("replay %d/%d: %w", msg.Partition, msg.Offset, err)
+		}
+		replayed++
+		return nil
+	})
+	fmt.Fprintf(os.Stderr, "replayed %d messages\n", replayed)
+	return err
+}
+
+// scan calls fn for every message of the topic matching the filter, oldest
+// first per partition, up to the end of each partition at the time of the
+// call.
+func scan(ctx context.Context, opts *options, fn func(kafka.Message) error) error {
+	conn, err := kafka.DialContext(ctx, "tcp", opts.brokers[0])
+	if err != nil {
+		return err
+	}
+	partitions, err := conn.ReadPartitions(opts.topic)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	matched := 0
+	for _, p := range partitions {
+		first, last, err := offsets(ctx, opts.brokers[0], opts.topic, p.ID)
+		if err != nil {
+			return err
+		}
+		if first >= last {
+			continue
+		}
+
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   opts.brokers,
+			Topic:     opts.topic,
+			Partition: p.ID,
+			MinBytes:  1,
+			MaxBytes:  10e6,
+		})
+		if err := reader.SetOffset(first); err != nil {
+			reader.Close()
+			return err
+		}
+		for {
+			msg, err := reader.ReadMessage(ctx)
+			if err != nil {
+				reader.Close()
+				return err
+			}
+			if opts.filter.Match(msg) {
+				if err := fn(msg); err != nil {
+					reader.Close()
+					return err
+				}
+				matched++
+				if opts.limit > 0 && matched >= opts.limit {
+					return reader.Close()
+				}
+			}
+			if msg.Offset >= last-1 {
+				break
+			}
+		}
+		reader.Close()
+	}
+	return nil
+}
+
+// offsets returns the first and the next offset of a partition.
+func offsets(ctx context.Context, broker, topic string, partition int) (first, last int64, err error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	return conn.ReadOffsets()
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,107 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the envelope version written by this build. Minor
+// versions only add optional fields, so readers accept any minor version of
+// a major they know.
+const SchemaVersion = "1.0"
+
+// Message types carried in Envelope.Type.
+const (
+	TypeTrackPoint    = "track_point"
+	TypeGeofenceEvent = "geofence_event"
+)
+
+var (
+	// ErrUnsupportedVersion is returned for envelopes of a major version this
+	// build doesn't know. Such messages belong in a dead-letter topic until
+	// the consumer is upgraded, not in the bin.
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	// ErrUnexpectedType is returned when an envelope carries a different
+	// message type than the topic is supposed to have.
+	ErrUnexpectedType = errors.New("unexpected message type")
+)
+
+// Envelope wraps every message published to Kafka.
+type Envelope struct {
+	SchemaVersion string `json:"schema_version"`
+	// MessageID is unique per published message, also across retries of the
+	// same payload by different producers.
+	MessageID string `json:"message_id"`
+	Type      string `json:"type"`
+	// Producer is the name of the publishing service.
+	Producer string `json:"producer"`
+	// Trace is the W3C trace context of the request that caused the message.
+	Trace TraceContext `json:"trace,omitzero"`
+	// EventTime is when the payload happened (GPS fix, geofence crossing),
+	// not when it was published.
+	EventTime time.Time       `json:"event_time"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// TraceContext holds W3C Trace Context headers.
+type TraceContext struct {
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// Legacy reports whether the message was published before envelopes were
+// introduced, as a bare payload.
+func (e Envelope) Legacy() bool {
+	return e.SchemaVersion == ""
+}
+
+// Marshal wraps payload in an envelope of the current schema version and
+// encodes it.
+func Marshal(msgType, producer string, eventTime time.Time, trace TraceContext, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		SchemaVersion: SchemaVersion,
+		MessageID:     rand.Text(),
+		Type:          msgType,
+		Producer:      producer,
+		Trace:         trace,
+		EventTime:     eventTime,
+		Payload:       data,
+	})
+}
+
+// Unmarshal decodes a message of msgType into v and returns its envelope.
+// Bare payloads from producers that predate envelopes are still accepted
+// and return an envelope for which Legacy is true.
+func Unmarshal(data []byte, msgType string, v any) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, err
+	}
+	if env.Legacy() {
+		return Envelope{Payload: data}, json.Unmarshal(data, v)
+	}
+	if major, _, _ := strings.Cut(env.SchemaVersion, "."); major != majorVersion() {
+		return env, fmt.Errorf("%w %q", ErrUnsupportedVersion, env.SchemaVersion)
+	}
+	if env.Type != msgType {
+		return env, fmt.Errorf("%w %q, want %q", ErrUnexpectedType, env.Type, msgType)
+	}
+	if len(bytes.TrimSpace(env.Payload)) == 0 {
+		return env, errors.New("envelope without payload")
+	}
+	return env, json.Unmarshal(env.Payload, v)
+}
+
+func majorVersion() string {
+	major, _, _ := strings.Cut(SchemaVersion, ".")
+	return major
+}
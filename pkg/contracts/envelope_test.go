@@ -0,0 +1,101 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	ts := time.Date(2026, 1, 23, 10, 0, 0, 0, time.UTC)
+	tp := TrackPoint{ContainerID: "CSQU3054383", Lat: 31.23, Lon: 121.47, Timestamp: ts, Speed: 5}
+	trace := TraceContext{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	data, err := Marshal(TypeTrackPoint, "telemetry", ts, trace, tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got TrackPoint
+	env, err := Unmarshal(data, TypeTrackPoint, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != tp {
+		t.Errorf("got %+v, want %+v", got, tp)
+	}
+	if env.Legacy() || env.SchemaVersion != SchemaVersion {
+		t.Errorf("got schema version %q, want %q", env.SchemaVersion, SchemaVersion)
+	}
+	if env.MessageID == "" || env.Producer != "telemetry" || !env.EventTime.Equal(ts) || env.Trace != trace {
+		t.Errorf("got envelope %+v", env)
+	}
+
+	other, _ := Marshal(TypeTrackPoint, "telemetry", ts, TraceContext{}, tp)
+	var otherEnv Envelope
+	json.Unmarshal(other, &otherEnv)
+	if otherEnv.MessageID == env.MessageID {
+		t.Error("message IDs should be unique")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    TrackPoint
+		legacy  bool
+		wantErr error
+	}{
+		{
+			name:   "legacy bare payload",
+			data:   `{"container_id":"CSQU3054383","lat":1,"lon":2,"timestamp":"2026-01-23T10:00:00Z","speed":0}`,
+			want:   TrackPoint{ContainerID: "CSQU3054383", Lat: 1, Lon: 2, Timestamp: time.Date(2026, 1, 23, 10, 0, 0, 0, time.UTC)},
+			legacy: true,
+		},
+		{
+			name: "newer minor version",
+			data: `{"schema_version":"1.7","message_id":"m1","type":"track_point","producer":"telemetry","event_time":"2026-01-23T10:00:00Z",` +
+				`"payload":{"container_id":"CSQU3054383","lat":1,"lon":2,"timestamp":"2026-01-23T10:00:00Z","speed":0,"new_field":true}}`,
+			want: TrackPoint{ContainerID: "CSQU3054383", Lat: 1, Lon: 2, Timestamp: time.Date(2026, 1, 23, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:    "unknown major version",
+			data:    `{"schema_version":"2.0","message_id":"m1","type":"track_point","payload":{}}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "wrong type",
+			data:    `{"schema_version":"1.0","message_id":"m1","type":"geofence_event","payload":{}}`,
+			wantErr: ErrUnexpectedType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got TrackPoint
+			env, err := Unmarshal([]byte(tt.data), TypeTrackPoint, &got)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) || got.ContainerID != tt.want.ContainerID || got.Lat != tt.want.Lat {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if env.Legacy() != tt.legacy {
+				t.Errorf("got legacy %v, want %v", env.Legacy(), tt.legacy)
+			}
+		})
+	}
+
+	var got TrackPoint
+	if _, err := Unmarshal([]byte("not json"), TypeTrackPoint, &got); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
@@ -0,0 +1,93 @@
+// Package contracts holds the messages exchanged between services over Kafka
+// and the envelope they travel in. Every service uses these types instead of
+// declaring its own copy, so producers and consumers can't drift apart.
+package contracts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// TrackPoint is a single GPS measurement from a container, published by
+// telemetry to container.telemetry.
+//
+// Sensor fields are optional pointers: nil means the device did not report
+// the value, which keeps older producers compatible.
+type TrackPoint struct {
+	ContainerID string    `json:"container_id"`
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Timestamp   time.Time `json:"timestamp"`
+	Speed       float64   `json:"speed"`
+	// PointID is an optional device-supplied ID or sequence number, unique
+	// per container. Retried uploads carrying the same ID are dropped.
+	PointID string `json:"point_id,omitempty"`
+
+	Heading     *float64 `json:"heading,omitempty"`     // degrees clockwise from true north, [0, 360)
+	Altitude    *float64 `json:"altitude,omitempty"`    // meters above sea level
+	Accuracy    *float64 `json:"accuracy,omitempty"`    // horizontal accuracy radius in meters
+	Battery     *float64 `json:"battery,omitempty"`     // percent, 0-100
+	Temperature *float64 `json:"temperature,omitempty"` // °C, cargo sensor for reefers
+	Humidity    *float64 `json:"humidity,omitempty"`    // relative humidity percent, 0-100
+	DoorOpen    *bool    `json:"door_open,omitempty"`
+
+	// QuarantineReason is set by the plausibility stage on points published
+	// to the quarantine topic instead of the main one.
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+}
+
+// Valid returns an error if the TrackPoint is invalid.
+func (t TrackPoint) Valid() error {
+	if t.ContainerID == "" {
+		return errors.New("container_id required")
+	}
+	// NaN compares false against every bound below, and neither NaN nor
+	// ±Inf can be marshaled to JSON later on
+	for _, f := range []struct {
+		name string
+		v    *float64
+	}{
+		{"lat", &t.Lat}, {"lon", &t.Lon}, {"speed", &t.Speed},
+		{"heading", t.Heading}, {"altitude", t.Altitude}, {"accuracy", t.Accuracy},
+		{"battery", t.Battery}, {"temperature", t.Temperature}, {"humidity", t.Humidity},
+	} {
+		if f.v != nil && (math.IsNaN(*f.v) || math.IsInf(*f.v, 0)) {
+			return fmt.Errorf("%s must be a finite number", f.name)
+		}
+	}
+	if t.Lat < -90 || t.Lat > 90 {
+		return errors.New("lat out of range")
+	}
+	if t.Lon < -180 || t.Lon > 180 {
+		return errors.New("lon out of range")
+	}
+	if t.Timestamp.IsZero() {
+		return errors.New("timestamp required")
+	}
+	if t.Speed < 0 {
+		return errors.New("speed cannot be negative")
+	}
+	if t.Heading != nil && (*t.Heading < 0 || *t.Heading >= 360) {
+		return errors.New("heading out of range")
+	}
+	// Dead Sea shore to above cruising altitude (air freight)
+	if t.Altitude != nil && (*t.Altitude < -500 || *t.Altitude > 15000) {
+		return errors.New("altitude out of range")
+	}
+	if t.Accuracy != nil && *t.Accuracy < 0 {
+		return errors.New("accuracy cannot be negative")
+	}
+	if t.Battery != nil && (*t.Battery < 0 || *t.Battery > 100) {
+		return errors.New("battery out of range")
+	}
+	// Widest range of common reefer cargo sensors
+	if t.Temperature != nil && (*t.Temperature < -70 || *t.Temperature > 85) {
+		return errors.New("temperature out of range")
+	}
+	if t.Humidity != nil && (*t.Humidity < 0 || *t.Humidity > 100) {
+		return errors.New("humidity out of range")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package dlq moves messages a consumer can't process to a dead-letter
+// topic and back. The original key, value and headers are kept byte for
+// byte, with x-dlq-* headers describing what went wrong, so a message can
+// be replayed onto its source topic once the consumer is fixed.
+package dlq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	HeaderPrefix    = "x-dlq-"
+	HeaderReason    = "x-dlq-reason"
+	HeaderError     = "x-dlq-error"
+	HeaderAttempts  = "x-dlq-attempts"
+	HeaderGroup     = "x-dlq-consumer-group"
+	HeaderTopic     = "x-dlq-source-topic"
+	HeaderPartition = "x-dlq-source-partition"
+	HeaderOffset    = "x-dlq-source-offset"
+	HeaderTime      = "x-dlq-time"
+)
+
+// Reason says why a message was dead-lettered.
+type Reason string
+
+const (
+	// ReasonDecode is a message that isn't a valid envelope or payload.
+	ReasonDecode Reason = "decode"
+	// ReasonUnsupportedVersion is an envelope from a newer producer.
+	ReasonUnsupportedVersion Reason = "unsupported_version"
+	// ReasonRetriesExhausted is a message of a batch that kept failing.
+	ReasonRetriesExhausted Reason = "retries_exhausted"
+)
+
+// Publisher writes dead letters of one consumer group to one topic.
+type Publisher struct {
+	writer *kafka.Writer
+	group  string
+}
+
+// NewPublisher creates a publisher for topic. Writes are synchronous with
+// acks=all: the caller commits the source offsets right after, so the dead
+// letters must be durable first.
+func NewPublisher(brokers []string, topic, group string) *Publisher {
+	return &Publisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		group: group,
+	}
+}
+
+// Publish dead-letters msgs with the reason, the error and the number of
+// processing attempts.
+func (p *Publisher) Publish(ctx context.Context, reason Reason, cause error, attempts int, msgs ...kafka.Message) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	out := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		out[i] = kafka.Message{
+			Key:   msg.Key,
+			Value: msg.Value,
+			Headers: append(msg.Headers[:len(msg.Headers):len(msg.Headers)],
+				kafka.Header{Key: HeaderReason, Value: []byte(reason)},
+				kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+				kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+				kafka.Header{Key: HeaderGroup, Value: []byte(p.group)},
+				kafka.Header{Key: HeaderTopic, Value: []byte(msg.Topic)},
+				kafka.Header{Key: HeaderPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+				kafka.Header{Key: HeaderOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+				kafka.Header{Key: HeaderTime, Value: []byte(now)},
+			),
+		}
+	}
+	return p.writer.WriteMessages(ctx, out...)
+}
+
+// Close closes the connection.
+func (p *Publisher) Close() error {
+	return p.writer.Close()
+}
+
+// Header returns the value of the first header named key.
+func Header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Restore turns a dead letter back into the message that was consumed from
+// the source topic and returns that topic.
+func Restore(msg kafka.Message) (topic string, original kafka.Message) {
+	original = kafka.Message{Key: msg.Key, Value: msg.Value}
+	for _, h := range msg.Headers {
+		if !strings.HasPrefix(h.Key, HeaderPrefix) {
+			original.Headers = append(original.Headers, h)
+		}
+	}
+	return Header(msg, HeaderTopic), original
+}
+
+// Filter selects dead letters. Zero fields match everything.
+type Filter struct {
+	Reason      Reason
+	SourceTopic string
+	Key         string
+	// ErrorContains matches a substring of the error header.
+	ErrorContains string
+	// Since and Until bound the time the message was dead-lettered.
+	Since, Until time.Time
+}
+
+// Match reports whether msg passes the filter.
+func (f Filter) Match(msg kafka.Message) bool {
+	if f.Reason != "" && Reason(Header(msg, HeaderReason)) != f.Reason {
+		return false
+	}
+	if f.SourceTopic != "" && Header(msg, HeaderTopic) != f.SourceTopic {
+		return false
+	}
+	if f.Key != "" && string(msg.Key) != f.Key {
+		return false
+	}
+	if f.ErrorContains != "" && !strings.Contains(Header(msg, HeaderError), f.ErrorContains) {
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		at, err := time.Parse(time.RFC3339, Header(msg, HeaderTime))
+		if err != nil {
+			return false
+		}
+		if !f.Since.IsZero() && at.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !at.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package dlq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func deadLetter(reason Reason, errText, at string) kafka.Message {
+	return kafka.Message{
+		Key:   []byte("CSQU3054383"),
+		Value: []byte(`{"schema_version":"2.0"}`),
+		Headers: []kafka.Header{
+			{Key: "traceparent", Value: []byte("00-abc-def-01")},
+			{Key: HeaderReason, Value: []byte(reason)},
+			{Key: HeaderError, Value: []byte(errText)},
+			{Key: HeaderTopic, Value: []byte("container.telemetry")},
+			{Key: HeaderTime, Value: []byte(at)},
+		},
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	msg := deadLetter(ReasonUnsupportedVersion, `unsupported schema version "2.0"`, "2026-03-01T12:00:00Z")
+	noon := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"reason", Filter{Reason: ReasonUnsupportedVersion}, true},
+		{"other reason", Filter{Reason: ReasonDecode}, false},
+		{"source topic", Filter{SourceTopic: "container.telemetry"}, true},
+		{"other source topic", Filter{SourceTopic: "geofence.events"}, false},
+		{"key", Filter{Key: "CSQU3054383"}, true},
+		{"other key", Filter{Key: "MSKU9070323"}, false},
+		{"error substring", Filter{ErrorContains: "2.0"}, true},
+		{"other error", Filter{ErrorContains: "timeout"}, false},
+		{"since inclusive", Filter{Since: noon}, true},
+		{"until exclusive", Filter{Until: noon}, false},
+		{"in range", Filter{Since: noon.Add(-time.Hour), Until: noon.Add(time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(msg); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	msg := deadLetter(ReasonDecode, "invalid character", "2026-03-01T12:00:00Z")
+
+	topic, original := Restore(msg)
+	if topic != "container.telemetry" {
+		t.Errorf("got topic %q, want %q", topic, "container.telemetry")
+	}
+	if string(original.Key) != string(msg.Key) || string(original.Value) != string(msg.Value) {
+		t.Errorf("got %+v, want key and value unchanged", original)
+	}
+	if len(original.Headers) != 1 || original.Headers[0].Key != "traceparent" {
+		t.Errorf("got headers %+v, want only traceparent", original.Headers)
+	}
+}
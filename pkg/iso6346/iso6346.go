@@ -0,0 +1,109 @@
+// Package iso6346 validates freight container codes such as "CSQU3054383":
+// a three-letter owner code, a category identifier, a six-digit serial
+// number and a check digit.
+package iso6346
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrLength     = errors.New("container code must be 11 characters")
+	ErrOwnerCode  = errors.New("owner code must be 3 letters")
+	ErrCategory   = errors.New("category identifier must be U, J or Z")
+	ErrSerial     = errors.New("serial number must be 6 digits")
+	ErrCheckDigit = errors.New("check digit mismatch")
+)
+
+// letterValues maps A-Z to 10-38, skipping multiples of 11.
+var letterValues = func() [26]int {
+	var values [26]int
+	v := 10
+	for i := range values {
+		if v%11 == 0 {
+			v++
+		}
+		values[i] = v
+		v++
+	}
+	return values
+}()
+
+// Normalize upper-cases code and drops the spaces and dashes used when the
+// code is printed on a container ("CSQU 305438 3").
+func Normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == '-':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return r
+		}
+	}, code)
+}
+
+// ValidateFormat checks owner code, category identifier and serial number
+// without the check digit.
+func ValidateFormat(code string) error {
+	if len(code) != 11 {
+		return ErrLength
+	}
+	for i := range 3 {
+		if !isLetter(code[i]) {
+			return ErrOwnerCode
+		}
+	}
+	switch code[3] {
+	case 'U', 'J', 'Z':
+	default:
+		return ErrCategory
+	}
+	for i := 4; i < 11; i++ {
+		if !isDigit(code[i]) {
+			return ErrSerial
+		}
+	}
+	return nil
+}
+
+// Validate checks the format and the check digit of a normalized code.
+func Validate(code string) error {
+	if err := ValidateFormat(code); err != nil {
+		return err
+	}
+	if int(code[10]-'0') != CheckDigit(code[:10]) {
+		return ErrCheckDigit
+	}
+	return nil
+}
+
+// Valid reports whether code is a valid container code.
+func Valid(code string) bool {
+	return Validate(code) == nil
+}
+
+// CheckDigit computes the check digit for the first 10 characters of a
+// well-formed code.
+func CheckDigit(prefix string) int {
+	sum := 0
+	for i := range 10 {
+		c := prefix[i]
+		v := int(c - '0')
+		if isLetter(c) {
+			v = letterValues[c-'A']
+		}
+		sum += v << i
+	}
+	return sum % 11 % 10
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
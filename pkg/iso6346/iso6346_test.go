@@ -0,0 +1,60 @@
+package iso6346
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		code string
+		want error
+	}{
+		{"CSQU3054383", nil},
+		{"MSKU9070323", nil},
+		{"TGHU1234567", nil},
+		{"CSQU0000070", nil}, // remainder 10 is written as 0
+		{"CSQU3054384", ErrCheckDigit},
+		{"CSQU305438", ErrLength},
+		{"C5QU3054383", ErrOwnerCode},
+		{"CSQX3054383", ErrCategory},
+		{"CSQU30543A3", ErrSerial},
+		{"csqu3054383", ErrOwnerCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if err := Validate(tt.code); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("csqu 305438-3"); got != "CSQU3054383" {
+		t.Errorf("got %q, want %q", got, "CSQU3054383")
+	}
+}
+
+func TestMode_Check(t *testing.T) {
+	tests := []struct {
+		mode Mode
+		code string
+		want error
+	}{
+		{ModeLenient, "C001", nil},
+		{ModeFormat, "C001", ErrLength},
+		{ModeFormat, "CSQU3054384", nil},
+		{ModeStrict, "CSQU3054384", ErrCheckDigit},
+		{ModeStrict, "CSQU3054383", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+"/"+tt.code, func(t *testing.T) {
+			if err := tt.mode.Check(tt.code); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
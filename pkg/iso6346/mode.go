@@ -0,0 +1,42 @@
+package iso6346
+
+import "fmt"
+
+// Mode is how strictly a service checks container codes. Services that
+// see the same points share one setting, so a code accepted at ingest is
+// not rejected again downstream.
+type Mode string
+
+const (
+	// ModeLenient accepts any code, e.g. for test fleets.
+	ModeLenient Mode = "lenient"
+	// ModeFormat requires owner code, category and serial but ignores the
+	// check digit, for devices provisioned with made-up codes.
+	ModeFormat Mode = "format"
+	// ModeStrict also verifies the check digit.
+	ModeStrict Mode = "strict"
+)
+
+// ParseMode parses a config value.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeLenient, ModeFormat, ModeStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown container ID mode %q", s)
+	}
+}
+
+// Check validates code according to the mode. The code must be normalized
+// first: the printed form "CSQU 305438 3" is not a valid code, but
+// Normalize turns it into one.
+func (m Mode) Check(code string) error {
+	switch m {
+	case ModeFormat:
+		return ValidateFormat(code)
+	case ModeStrict:
+		return Validate(code)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,245 @@
+// Package kafkaconsumer reads a Kafka topic in batches of decoded messages.
+// It is shared by the services reading container.telemetry and
+// geofence.events, which differ only in the message type.
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/lai/logistics/pkg/dlq"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultMaxRetryBackoff = 30 * time.Second
+	defaultMaxAttempts     = 10
+
+	// queueBatches is how many batches of messages a worker can have queued
+	// before fetching blocks.
+	queueBatches = 4
+)
+
+// Decoder turns a Kafka message into a T. Messages it rejects are
+// dead-lettered.
+type Decoder[T any] func(kafka.Message) (T, error)
+
+// Envelope decodes contracts envelopes of msgType.
+func Envelope[T any](msgType string) Decoder[T] {
+	return func(msg kafka.Message) (T, error) {
+		var v T
+		_, err := contracts.Unmarshal(msg.Value, msgType, &v)
+		return v, err
+	}
+}
+
+// OnBatch is called with each batch of decoded messages. A batch that
+// returns an error is retried, and its offsets are only committed once it
+// succeeded or was dead-lettered, so OnBatch must tolerate seeing messages
+// again. With more than one worker it is called concurrently, for batches
+// of different partitions.
+//
+// ctx carries a span for the batch; ItemContext continues the trace of a
+// single message instead.
+type OnBatch[T any] func(ctx context.Context, batch []T) error
+
+type itemsKey struct{}
+
+// ItemContext returns ctx with the span that produced the i-th message of
+// the batch as parent, so work done for that message continues its trace.
+// Outside OnBatch, or for a message sent without trace context, it returns
+// ctx unchanged.
+func ItemContext(ctx context.Context, i int) context.Context {
+	items, _ := ctx.Value(itemsKey{}).([]trace.SpanContext)
+	if i < 0 || i >= len(items) || !items[i].IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, items[i])
+}
+
+// Config holds configuration for a Consumer.
+type Config struct {
+	Brokers      []string
+	Topic        string
+	GroupID      string
+	BatchSize    int
+	BatchTimeout time.Duration
+	// Workers is the number of goroutines processing batches. Each
+	// partition is handled by one worker, so messages with the same key stay
+	// in order. More workers than partitions are idle. Default 1.
+	Workers int
+	// DeadLetterTopic receives undecodable messages and the messages of
+	// batches that failed MaxAttempts times.
+	DeadLetterTopic string
+	// MaxAttempts is how often a batch is tried before it is dead-lettered.
+	// Default 10, which with the default backoff is about two minutes.
+	MaxAttempts int
+	// RetryBackoff is the wait before the first retry of a failed batch. It
+	// doubles per attempt up to MaxRetryBackoff. Default 500ms and 30s.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// ShutdownTimeout bounds the drain after Run's context is cancelled.
+	// Default 10s.
+	ShutdownTimeout time.Duration
+}
+
+// Reader is the part of *kafka.Reader a Consumer uses.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// DeadLetterer is the part of *dlq.Publisher a Consumer uses.
+type DeadLetterer interface {
+	Publish(ctx context.Context, reason dlq.Reason, cause error, attempts int, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consumer batches messages by size and timeout. Offsets are committed
+// after the batch containing them was processed or dead-lettered, never
+// before, so a crash or a failing OnBatch never loses a message
+// (at-least-once).
+type Consumer[T any] struct {
+	cfg         Config
+	reader      Reader
+	deadLetters DeadLetterer
+	decode      Decoder[T]
+	onBatch     OnBatch[T]
+}
+
+// New creates a consumer for cfg.
+func New[T any](cfg Config, decode Decoder[T], onBatch OnBatch[T]) *Consumer[T] {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:        cfg.Brokers,
+		Topic:          cfg.Topic,
+		GroupID:        cfg.GroupID,
+		MinBytes:       1,
+		MaxBytes:       10e6, // 10MB
+		CommitInterval: time.Second,
+		StartOffset:    kafka.FirstOffset,
+	})
+	return NewWithReader(cfg, reader, dlq.NewPublisher(cfg.Brokers, cfg.DeadLetterTopic, cfg.GroupID), decode, onBatch)
+}
+
+// NewWithReader creates a consumer reading from reader and dead-lettering
+// to deadLetters, for tests and callers managing their own reader.
+func NewWithReader[T any](cfg Config, reader Reader, deadLetters DeadLetterer, decode Decoder[T], onBatch OnBatch[T]) *Consumer[T] {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.MaxRetryBackoff <= 0 {
+		cfg.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	return &Consumer[T]{
+		cfg:         cfg,
+		reader:      reader,
+		deadLetters: deadLetters,
+		decode:      decode,
+		onBatch:     onBatch,
+	}
+}
+
+// Run consumes messages until ctx is cancelled, then drains: fetching
+// stops, the workers finish the batch in hand and process what they had
+// queued, and the offsets of everything processed are committed. Run
+// returns once the drain finished or ShutdownTimeout passed; whatever was
+// not processed by then stays uncommitted and is redelivered. Close the
+// Consumer only after Run returned, since closing the reader flushes the
+// last commits.
+//
+// A single goroutine fetches and hands each message to the worker owning
+// its partition. A worker retrying a batch stops taking messages, and once
+// its queue of a few batches is full fetching blocks for all partitions:
+// the reader can't pause single partitions, so a stuck partition holds the
+// others back after that.
+func (c *Consumer[T]) Run(ctx context.Context) {
+	slog.Info("starting Kafka consumer",
+		"brokers", c.cfg.Brokers,
+		"topic", c.cfg.Topic,
+		"group_id", c.cfg.GroupID,
+		"workers", c.cfg.Workers,
+	)
+
+	// Workers outlive ctx so a batch in hand isn't interrupted by shutdown,
+	// only by the drain deadline
+	work, stopWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWork()
+
+	var wg sync.WaitGroup
+	queues := make([]chan kafka.Message, c.cfg.Workers)
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, queueBatches*max(c.cfg.BatchSize, 1))
+		w := &worker[T]{c: c, msgs: queues[i]}
+		wg.Go(func() { w.run(work) })
+	}
+
+	c.fetch(ctx, queues)
+
+	slog.Info("draining Kafka consumer", "topic", c.cfg.Topic, "timeout", c.cfg.ShutdownTimeout)
+	for _, q := range queues {
+		close(q)
+	}
+	deadline := time.AfterFunc(c.cfg.ShutdownTimeout, stopWork)
+	defer deadline.Stop()
+	wg.Wait()
+	if work.Err() != nil {
+		slog.Warn("drain deadline exceeded, unprocessed messages will be redelivered", "topic", c.cfg.Topic)
+	}
+}
+
+// fetch hands messages to the workers until ctx is cancelled.
+func (c *Consumer[T]) fetch(ctx context.Context, queues []chan kafka.Message) {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("fetch message failed", "error", err)
+			if !sleep(ctx, c.cfg.RetryBackoff) {
+				return
+			}
+			continue
+		}
+		lag := max(msg.HighWaterMark-msg.Offset-1, 0)
+		consumerLag.WithLabelValues(c.cfg.Topic, strconv.Itoa(msg.Partition)).Set(float64(lag))
+		select {
+		case queues[msg.Partition%len(queues)] <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close closes the Kafka reader and the dead-letter writer.
+func (c *Consumer[T]) Close() error {
+	return errors.Join(c.reader.Close(), c.deadLetters.Close())
+}
+
+// sleep waits for d and reports false if ctx ended first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,552 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/lai/logistics/pkg/dlq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeReader serves queued messages and records commits.
+type fakeReader struct {
+	msgs chan kafka.Message
+
+	mu        sync.Mutex
+	committed []int64
+}
+
+func newFakeReader(msgs ...kafka.Message) *fakeReader {
+	r := &fakeReader{msgs: make(chan kafka.Message, 100)}
+	for _, m := range msgs {
+		r.msgs <- m
+	}
+	return r
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func (r *fakeReader) commits() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.committed)
+}
+
+type fakeDeadLetterer struct {
+	mu      sync.Mutex
+	offsets []int64
+	reasons []dlq.Reason
+}
+
+func (d *fakeDeadLetterer) Publish(_ context.Context, reason dlq.Reason, _ error, _ int, msgs ...kafka.Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, msg := range msgs {
+		d.offsets = append(d.offsets, msg.Offset)
+		d.reasons = append(d.reasons, reason)
+	}
+	return nil
+}
+
+func (d *fakeDeadLetterer) Close() error { return nil }
+
+func eventMessage(t *testing.T, offset int64, containerID string) kafka.Message {
+	t.Helper()
+	evt := contracts.GeofenceEvent{ContainerID: containerID, EventType: "enter"}
+	data, err := contracts.Marshal(contracts.TypeGeofenceEvent, "test", time.Now(), contracts.TraceContext{}, evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kafka.Message{Offset: offset, Value: data}
+}
+
+// batchRecorder collects batches and what was committed when each arrived.
+// The first failures calls return an error.
+type batchRecorder struct {
+	reader   *fakeReader
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	batches  [][]string
+	before   [][]int64
+}
+
+func (b *batchRecorder) onBatch(_ context.Context, events []contracts.GeofenceEvent) error {
+	var ids []string
+	for _, e := range events {
+		ids = append(ids, e.ContainerID)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts++
+	if b.attempts <= b.failures {
+		return errors.New("database unavailable")
+	}
+	b.batches = append(b.batches, ids)
+	b.before = append(b.before, b.reader.commits())
+	return nil
+}
+
+func (b *batchRecorder) attemptCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempts
+}
+
+func (b *batchRecorder) get() [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.batches)
+}
+
+func runConsumer(t *testing.T, c *Consumer[contracts.GeofenceEvent], until func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !until() {
+		if time.Now().After(deadline) {
+			cancel()
+			<-done
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestConsumer_BatchesBySize(t *testing.T) {
+	reader := newFakeReader(
+		eventMessage(t, 0, "A"), eventMessage(t, 1, "B"),
+		eventMessage(t, 2, "C"), eventMessage(t, 3, "D"),
+	)
+	rec := &batchRecorder{reader: reader}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(rec.get()) == 2 })
+
+	want := [][]string{{"A", "B"}, {"C", "D"}}
+	if got := rec.get(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+	if got := reader.commits(); !slices.Equal(got, []int64{0, 1, 2, 3}) {
+		t.Errorf("got commits %v, want [0 1 2 3]", got)
+	}
+}
+
+func TestConsumer_FlushesOnTimeout(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"))
+	rec := &batchRecorder{reader: reader}
+	cfg := Config{BatchSize: 100, BatchTimeout: 20 * time.Millisecond}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(rec.get()) == 1 })
+
+	if got := reader.commits(); !slices.Equal(got, []int64{0}) {
+		t.Errorf("got commits %v, want [0]", got)
+	}
+}
+
+func TestConsumer_CommitsAfterBatch(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"), eventMessage(t, 1, "B"))
+	rec := &batchRecorder{reader: reader}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 2 })
+
+	if len(rec.before) != 1 || len(rec.before[0]) != 0 {
+		t.Errorf("offsets committed before the batch was processed: %v", rec.before)
+	}
+}
+
+func TestConsumer_RetriesFailedBatch(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"), eventMessage(t, 1, "B"))
+	rec := &batchRecorder{reader: reader, failures: 2}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour, RetryBackoff: time.Millisecond}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 2 })
+
+	if got := rec.attemptCount(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+	want := [][]string{{"A", "B"}}
+	if got := rec.get(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+	if len(rec.before[0]) != 0 {
+		t.Errorf("offsets committed before the batch succeeded: %v", rec.before[0])
+	}
+}
+
+func TestConsumer_LeavesFailedBatchUncommitted(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"))
+	rec := &batchRecorder{reader: reader, failures: 1 << 30}
+	cfg := Config{
+		BatchSize:       1,
+		BatchTimeout:    time.Hour,
+		RetryBackoff:    time.Millisecond,
+		MaxRetryBackoff: time.Millisecond,
+		MaxAttempts:     1 << 30,
+		ShutdownTimeout: 20 * time.Millisecond,
+	}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return rec.attemptCount() >= 5 })
+
+	if got := reader.commits(); len(got) != 0 {
+		t.Errorf("got commits %v, want none", got)
+	}
+}
+
+func TestConsumer_DeadLettersExhaustedBatch(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"), eventMessage(t, 1, "B"))
+	rec := &batchRecorder{reader: reader, failures: 1 << 30}
+	deadLetters := &fakeDeadLetterer{}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour, RetryBackoff: time.Millisecond, MaxAttempts: 3}
+	c := NewWithReader(cfg, reader, deadLetters, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 2 })
+
+	if got := rec.attemptCount(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+	if !slices.Equal(deadLetters.offsets, []int64{0, 1}) {
+		t.Errorf("got dead letters %v, want [0 1]", deadLetters.offsets)
+	}
+	if deadLetters.reasons[0] != dlq.ReasonRetriesExhausted {
+		t.Errorf("got reason %q, want %q", deadLetters.reasons[0], dlq.ReasonRetriesExhausted)
+	}
+}
+
+func TestConsumer_FlushesOnShutdown(t *testing.T) {
+	reader := newFakeReader(eventMessage(t, 0, "A"))
+	rec := &batchRecorder{reader: reader}
+	cfg := Config{BatchSize: 100, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.msgs) == 0 })
+
+	if got := rec.get(); len(got) != 1 {
+		t.Errorf("got batches %v, want the pending one flushed", got)
+	}
+	if got := reader.commits(); !slices.Equal(got, []int64{0}) {
+		t.Errorf("got commits %v, want [0]", got)
+	}
+}
+
+func TestConsumer_RejectsUndecodable(t *testing.T) {
+	reader := newFakeReader(
+		kafka.Message{Offset: 0, Value: []byte("not json")},
+		kafka.Message{Offset: 1, Value: []byte(`{"schema_version":"2.0","type":"geofence_event","payload":{}}`)},
+		eventMessage(t, 2, "A"),
+	)
+	rec := &batchRecorder{reader: reader}
+	deadLetters := &fakeDeadLetterer{}
+	cfg := Config{BatchSize: 1, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, deadLetters, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(rec.get()) == 1 })
+
+	if got := rec.get(); !slices.Equal(got[0], []string{"A"}) {
+		t.Errorf("got batches %v, want [[A]]", got)
+	}
+	if !slices.Equal(deadLetters.offsets, []int64{0, 1}) {
+		t.Errorf("got dead letters %v, want [0 1]", deadLetters.offsets)
+	}
+	if want := []dlq.Reason{dlq.ReasonDecode, dlq.ReasonUnsupportedVersion}; !slices.Equal(deadLetters.reasons, want) {
+		t.Errorf("got reasons %v, want %v", deadLetters.reasons, want)
+	}
+	// Rejected messages are committed together with the next batch
+	if got := reader.commits(); !slices.Equal(got, []int64{0, 1, 2}) {
+		t.Errorf("got commits %v, want [0 1 2]", got)
+	}
+}
+
+func TestEnvelope_Legacy(t *testing.T) {
+	decode := Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent)
+	evt, err := decode(kafka.Message{Value: []byte(`{"container_id":"A","event_type":"exit"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.ContainerID != "A" || evt.EventType != "exit" {
+		t.Errorf("got %+v", evt)
+	}
+	if _, err := decode(kafka.Message{Value: []byte(`{"schema_version":"9.0"}`)}); !errors.Is(err, contracts.ErrUnsupportedVersion) {
+		t.Errorf("got error %v, want %v", err, contracts.ErrUnsupportedVersion)
+	}
+}
+
+func TestConsumer_ProcessesPartitionsConcurrently(t *testing.T) {
+	var msgs []kafka.Message
+	for i := range 6 {
+		msg := eventMessage(t, int64(i/2), []string{"A", "B"}[i%2]+strconv.Itoa(i/2))
+		msg.Partition = i % 2
+		msgs = append(msgs, msg)
+	}
+	reader := newFakeReader(msgs...)
+
+	// Partition 0's first batch only finishes once partition 1 was processed,
+	// which deadlocks unless the partitions run in parallel
+	partition1Done := make(chan struct{})
+	var once sync.Once
+	var mu sync.Mutex
+	var order []string
+	onBatch := func(ctx context.Context, events []contracts.GeofenceEvent) error {
+		if events[0].ContainerID[0] == 'A' {
+			select {
+			case <-partition1Done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range events {
+			order = append(order, e.ContainerID)
+		}
+		if events[len(events)-1].ContainerID == "B2" {
+			once.Do(func() { close(partition1Done) })
+		}
+		return nil
+	}
+	cfg := Config{BatchSize: 1, BatchTimeout: time.Hour, Workers: 2}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 6 })
+
+	var a, b []string
+	for _, id := range order {
+		if id[0] == 'A' {
+			a = append(a, id)
+		} else {
+			b = append(b, id)
+		}
+	}
+	if !slices.Equal(a, []string{"A0", "A1", "A2"}) || !slices.Equal(b, []string{"B0", "B1", "B2"}) {
+		t.Errorf("got order %v, want each partition in offset order", order)
+	}
+	if order[0] != "B0" {
+		t.Errorf("got order %v, want partition 1 to finish first", order)
+	}
+}
+
+// startConsumer runs c until the returned stop is called, which cancels
+// Run's context and waits for the drain.
+func startConsumer(c *Consumer[contracts.GeofenceEvent]) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsumer_DrainsOnShutdown(t *testing.T) {
+	reader := newFakeReader(
+		eventMessage(t, 0, "A"), eventMessage(t, 1, "B"), eventMessage(t, 2, "C"),
+		eventMessage(t, 3, "D"), eventMessage(t, 4, "E"),
+	)
+	rec := &batchRecorder{reader: reader}
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var interrupted bool
+	onBatch := func(ctx context.Context, events []contracts.GeofenceEvent) error {
+		if events[0].ContainerID == "A" {
+			close(entered)
+			<-release
+			interrupted = ctx.Err() != nil
+		}
+		return rec.onBatch(ctx, events)
+	}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour, ShutdownTimeout: 2 * time.Second}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), onBatch)
+
+	stop := startConsumer(c)
+	<-entered
+	waitFor(t, func() bool { return len(reader.msgs) == 0 })
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Run returned while a batch was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+
+	if interrupted {
+		t.Error("in-flight batch saw a cancelled context")
+	}
+	// The batch in flight, the queued one and the partial one
+	want := [][]string{{"A", "B"}, {"C", "D"}, {"E"}}
+	if got := rec.get(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+	if got := reader.commits(); !slices.Equal(got, []int64{0, 1, 2, 3, 4}) {
+		t.Errorf("got commits %v, want [0 1 2 3 4]", got)
+	}
+}
+
+func TestConsumer_RestartRedeliversUndrained(t *testing.T) {
+	var msgs []kafka.Message
+	for i, id := range []string{"A", "B", "C", "D", "E", "F"} {
+		msgs = append(msgs, eventMessage(t, int64(i), id))
+	}
+
+	var mu sync.Mutex
+	var processed []string
+	record := func(events []contracts.GeofenceEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range events {
+			processed = append(processed, e.ContainerID)
+		}
+	}
+
+	// The first run hangs on its second batch until the drain deadline
+	reader := newFakeReader(msgs...)
+	onBatch := func(ctx context.Context, events []contracts.GeofenceEvent) error {
+		if events[0].ContainerID == "C" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		record(events)
+		return nil
+	}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour, ShutdownTimeout: 20 * time.Millisecond}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), onBatch)
+	stop := startConsumer(c)
+	waitFor(t, func() bool { return len(reader.commits()) == 2 && len(reader.msgs) == 0 })
+	stop()
+
+	committed := reader.commits()
+	if !slices.Equal(committed, []int64{0, 1}) {
+		t.Fatalf("got commits %v, want [0 1]", committed)
+	}
+
+	// The second run starts after the last committed offset, like a group
+	// member taking over the partition
+	reader = newFakeReader(msgs[slices.Max(committed)+1:]...)
+	onBatch = func(_ context.Context, events []contracts.GeofenceEvent) error {
+		record(events)
+		return nil
+	}
+	c = NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), onBatch)
+	stop = startConsumer(c)
+	waitFor(t, func() bool { return len(reader.commits()) == 4 })
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"A", "B", "C", "D", "E", "F"}; !slices.Equal(processed, want) {
+		t.Errorf("got processed %v, want each message exactly once: %v", processed, want)
+	}
+}
+
+func TestConsumer_ReportsLag(t *testing.T) {
+	msg := eventMessage(t, 7, "A")
+	msg.Partition = 2
+	msg.HighWaterMark = 20
+	reader := newFakeReader(msg)
+	rec := &batchRecorder{reader: reader}
+	cfg := Config{Topic: "lag-test", BatchSize: 1, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), rec.onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 1 })
+
+	if got := testutil.ToFloat64(consumerLag.WithLabelValues("lag-test", "2")); got != 12 {
+		t.Errorf("got lag %v, want 12", got)
+	}
+	if got := testutil.CollectAndCount(batchSize, "kafka_consumer_batch_size"); got == 0 {
+		t.Error("batch size not observed")
+	}
+}
+
+func TestConsumer_ItemContextContinuesMessageTrace(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	traced := eventMessage(t, 0, "A")
+	traced.Headers = []kafka.Header{{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")}}
+	reader := newFakeReader(traced, eventMessage(t, 1, "B"))
+
+	var mu sync.Mutex
+	var traceIDs []string
+	onBatch := func(ctx context.Context, events []contracts.GeofenceEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range events {
+			sc := trace.SpanContextFromContext(ItemContext(ctx, i))
+			traceIDs = append(traceIDs, sc.TraceID().String())
+		}
+		return nil
+	}
+	cfg := Config{BatchSize: 2, BatchTimeout: time.Hour}
+	c := NewWithReader(cfg, reader, &fakeDeadLetterer{}, Envelope[contracts.GeofenceEvent](contracts.TypeGeofenceEvent), onBatch)
+
+	runConsumer(t, c, func() bool { return len(reader.commits()) == 2 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traceIDs) != 2 || traceIDs[0] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("got trace IDs %v, want the first from its traceparent", traceIDs)
+	}
+	// Without a global tracer provider the batch has no span of its own
+	if traceIDs[1] != (trace.TraceID{}).String() {
+		t.Errorf("got trace ID %s for the untraced message, want none", traceIDs[1])
+	}
+}
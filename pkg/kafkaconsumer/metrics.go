@@ -0,0 +1,35 @@
+package kafkaconsumer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Messages behind the partition's high watermark as of the last fetch.",
+	}, []string{"topic", "partition"})
+
+	batchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_consumer_batch_size",
+		Help:    "Messages per processed batch.",
+		Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+	}, []string{"topic"})
+
+	flushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_consumer_flush_duration_seconds",
+		Help:    "Time to process a batch, including retries, and commit its offsets.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14), // 5ms to ~41s
+	}, []string{"topic"})
+
+	batchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_consumer_batch_failures_total",
+		Help: "Failed attempts to process a batch.",
+	}, []string{"topic"})
+
+	deadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_consumer_dead_lettered_total",
+		Help: "Messages moved to the dead-letter topic, by reason.",
+	}, []string{"topic", "reason"})
+)
@@ -0,0 +1,177 @@
+package kafkaconsumer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/lai/logistics/pkg/dlq"
+	"github.com/lai/logistics/pkg/tracing"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// worker batches the messages of the partitions assigned to it. All its
+// state is owned by its goroutine.
+type worker[T any] struct {
+	c    *Consumer[T]
+	msgs <-chan kafka.Message
+
+	batch     []T
+	batchMsgs []kafka.Message // the messages batch was decoded from
+	pending   []kafka.Message // everything taken since the last commit
+}
+
+// run batches messages until its queue is closed and drained, then
+// flushes the last batch. If ctx ends first it returns at once, leaving
+// the batch uncommitted. The batch timeout counts from the first message
+// after a flush.
+func (w *worker[T]) run(ctx context.Context) {
+	timer := time.NewTimer(w.c.cfg.BatchTimeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			w.flush(ctx)
+		case msg, ok := <-w.msgs:
+			if !ok {
+				w.flush(ctx)
+				return
+			}
+			if !w.add(ctx, msg) {
+				continue
+			}
+			if len(w.pending) == 1 {
+				timer.Reset(w.c.cfg.BatchTimeout)
+			}
+			if len(w.batch) >= w.c.cfg.BatchSize {
+				w.flush(ctx)
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// add decodes msg into the batch, or dead-letters it. It reports false if
+// ctx ended before an undecodable message was dead-lettered.
+func (w *worker[T]) add(ctx context.Context, msg kafka.Message) bool {
+	v, err := w.c.decode(msg)
+	if err != nil {
+		if err := w.reject(ctx, msg, err); err != nil {
+			// Shutting down; the message stays uncommitted
+			return false
+		}
+		w.pending = append(w.pending, msg)
+		return true
+	}
+	w.pending = append(w.pending, msg)
+	w.batch = append(w.batch, v)
+	w.batchMsgs = append(w.batchMsgs, msg)
+	return true
+}
+
+// reject dead-letters an undecodable message, retrying until the dead
+// letter is written or ctx ends. Messages from a newer producer can be
+// replayed once the service is upgraded.
+func (w *worker[T]) reject(ctx context.Context, msg kafka.Message, cause error) error {
+	reason := dlq.ReasonDecode
+	if errors.Is(cause, contracts.ErrUnsupportedVersion) {
+		reason = dlq.ReasonUnsupportedVersion
+	}
+	backoff := w.c.cfg.RetryBackoff
+	for {
+		err := w.c.deadLetters.Publish(ctx, reason, cause, 0, msg)
+		if err == nil {
+			deadLettered.WithLabelValues(w.c.cfg.Topic, string(reason)).Inc()
+			slog.Warn("dead-lettered message",
+				"reason", reason,
+				"error", cause,
+				"partition", msg.Partition,
+				"offset", msg.Offset,
+			)
+			return nil
+		}
+		slog.Error("dead-letter publish failed", "error", err, "partition", msg.Partition, "offset", msg.Offset)
+		if !sleep(ctx, backoff) {
+			return err
+		}
+		backoff = min(backoff*2, w.c.cfg.MaxRetryBackoff)
+	}
+}
+
+// flush processes the batch and then commits every message taken since
+// the last flush, including dead-lettered ones. If ctx ends before the
+// batch was done nothing is committed and the messages are redelivered to
+// whoever consumes the partitions next.
+func (w *worker[T]) flush(ctx context.Context) {
+	if len(w.batch) > 0 {
+		start := time.Now()
+		batchSize.WithLabelValues(w.c.cfg.Topic).Observe(float64(len(w.batch)))
+		defer func() {
+			flushDuration.WithLabelValues(w.c.cfg.Topic).Observe(time.Since(start).Seconds())
+		}()
+		if err := w.process(ctx); err != nil {
+			slog.Error("batch abandoned uncommitted", "error", err, "count", len(w.batch))
+			return
+		}
+		w.batch = make([]T, 0, w.c.cfg.BatchSize)
+		w.batchMsgs = w.batchMsgs[:0]
+	}
+	if len(w.pending) == 0 {
+		return
+	}
+	if err := w.c.reader.CommitMessages(ctx, w.pending...); err != nil {
+		// The batch is done; a redelivery after this is a duplicate, not a loss
+		slog.Error("commit failed", "error", err, "count", len(w.pending))
+	}
+	w.pending = w.pending[:0]
+}
+
+// process calls OnBatch until it succeeds, or dead-letters the batch once
+// it failed MaxAttempts times. It gives up when ctx ends.
+func (w *worker[T]) process(ctx context.Context) error {
+	items := make([]trace.SpanContext, len(w.batchMsgs))
+	for i, msg := range w.batchMsgs {
+		items[i] = trace.SpanContextFromContext(tracing.Extract(context.Background(), msg))
+	}
+	backoff := w.c.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		spanCtx, span := tracing.StartProcess(ctx, w.c.cfg.Topic, w.c.cfg.GroupID, w.batchMsgs)
+		err := w.c.onBatch(context.WithValue(spanCtx, itemsKey{}, items), w.batch)
+		tracing.End(span, err)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		batchFailures.WithLabelValues(w.c.cfg.Topic).Inc()
+		if attempt >= w.c.cfg.MaxAttempts {
+			dlqErr := w.c.deadLetters.Publish(ctx, dlq.ReasonRetriesExhausted, err, attempt, w.batchMsgs...)
+			if dlqErr == nil {
+				deadLettered.WithLabelValues(w.c.cfg.Topic, string(dlq.ReasonRetriesExhausted)).Add(float64(len(w.batchMsgs)))
+				slog.Error("batch dead-lettered", "error", err, "count", len(w.batch), "attempts", attempt)
+				return nil
+			}
+			// Keep retrying; the batch may yet succeed or the DLQ come back
+			slog.Error("dead-letter publish failed", "error", dlqErr, "count", len(w.batch))
+		} else {
+			slog.Warn("batch failed, retrying",
+				"error", err,
+				"count", len(w.batch),
+				"attempt", attempt,
+				"backoff", backoff,
+			)
+		}
+		if !sleep(ctx, backoff) {
+			return err
+		}
+		backoff = min(backoff*2, w.c.cfg.MaxRetryBackoff)
+	}
+}
@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier reads and writes trace context as Kafka message headers.
+type headerCarrier struct {
+	msg *kafka.Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(c.msg.Headers))
+	for i, h := range c.msg.Headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
+// Inject writes the trace context of ctx into the headers of msg
+// (traceparent and tracestate).
+func Inject(ctx context.Context, msg *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{msg})
+}
+
+// Extract returns ctx carrying the trace context from the headers of msg.
+func Extract(ctx context.Context, msg kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{&msg})
+}
+
+// Envelope returns the trace context of ctx for contracts.Marshal, which
+// keeps a copy in the envelope for tools that drop headers.
+func Envelope(ctx context.Context) contracts.TraceContext {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return contracts.TraceContext{
+		TraceParent: carrier.Get("traceparent"),
+		TraceState:  carrier.Get("tracestate"),
+	}
+}
+
+// StartSend starts the producer span for publishing messages to topic.
+// Inject the returned context into the messages.
+func StartSend(ctx context.Context, topic string, count int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "send "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingBatchMessageCount(count),
+		),
+	)
+}
+
+// StartProcess starts the consumer span for a batch read from topic. It
+// links to the producer span of every message instead of picking one as
+// parent.
+func StartProcess(ctx context.Context, topic, group string, msgs []kafka.Message) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		sc := trace.SpanContextFromContext(Extract(context.Background(), msg))
+		if sc.IsValid() {
+			links = append(links, trace.Link{
+				SpanContext: sc,
+				Attributes: []attribute.KeyValue{
+					semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+					semconv.MessagingKafkaOffset(int(msg.Offset)),
+				},
+			})
+		}
+	}
+	return tracer.Start(ctx, "process "+topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingConsumerGroupName(group),
+			semconv.MessagingBatchMessageCount(len(msgs)),
+		),
+	)
+}
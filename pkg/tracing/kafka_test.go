@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestInjectExtract(t *testing.T) {
+	ctx := testContext(t)
+	msg := kafka.Message{Headers: []kafka.Header{{Key: "traceparent", Value: []byte("stale")}}}
+	Inject(ctx, &msg)
+
+	if len(msg.Headers) != 1 {
+		t.Fatalf("got headers %v, want traceparent replaced", msg.Headers)
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if got := string(msg.Headers[0].Value); got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), msg))
+	if !got.IsRemote() || got.TraceID() != trace.SpanContextFromContext(ctx).TraceID() {
+		t.Errorf("got span context %+v, want the injected one as remote", got)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	tc := Envelope(testContext(t))
+	if tc.TraceParent != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("got traceparent %q", tc.TraceParent)
+	}
+	if tc := Envelope(context.Background()); tc.TraceParent != "" {
+		t.Errorf("got traceparent %q without a span, want none", tc.TraceParent)
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"-- name: GetState :one\nSELECT inside FROM geofence_state": "GetState",
+		"select 1":                 "SELECT",
+		"  INSERT INTO containers": "INSERT",
+		"":                         "query",
+	}
+	for sql, want := range tests {
+		if got := queryName(sql); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
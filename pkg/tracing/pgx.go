@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PgxTracer records a span per query and COPY. Set it as the Tracer of a
+// pgx.ConnConfig.
+type PgxTracer struct{}
+
+var (
+	_ pgx.QueryTracer    = PgxTracer{}
+	_ pgx.CopyFromTracer = PgxTracer{}
+)
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, queryName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBQueryText(data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	End(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (PgxTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	ctx, _ = tracer.Start(ctx, "COPY "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName("COPY"),
+			semconv.DBCollectionName(table),
+		),
+	)
+	return ctx
+}
+
+func (PgxTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(semconv.DBResponseReturnedRows(int(data.CommandTag.RowsAffected())))
+	End(span, data.Err)
+}
+
+// queryName names a span after the sqlc query ("-- name: GetState :one"),
+// or the first keyword of hand-written SQL.
+func queryName(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	if verb, _, _ := strings.Cut(sql, " "); verb != "" {
+		return strings.ToUpper(verb)
+	}
+	return "query"
+}
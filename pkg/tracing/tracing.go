@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry and carries W3C trace context
+// through Kafka headers and Postgres queries, so a GPS point can be followed
+// from the telemetry API through the consumer and the rule engine to the
+// notification email.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scope = "github.com/lai/logistics/pkg/tracing"
+
+var tracer = otel.Tracer(scope)
+
+// Setup installs the global tracer provider and the W3C propagator for
+// service. OTEL_TRACES_EXPORTER picks the exporter:
+//   - otlp: OTLP over HTTP, configured by the standard OTEL_EXPORTER_OTLP_*
+//     variables. The default when OTEL_EXPORTER_OTLP_ENDPOINT is set
+//   - console: pretty-printed spans on stdout, for local runs
+//   - none: no export. The default otherwise; trace context is still
+//     propagated, so services further down can record it
+//
+// Sampling follows OTEL_TRACES_SAMPLER, by default every trace. The
+// returned shutdown flushes buffered spans.
+func Setup(ctx context.Context, service string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporterName := os.Getenv("OTEL_TRACES_EXPORTER")
+	if exporterName == "" {
+		exporterName = "none"
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+			exporterName = "otlp"
+		}
+	}
+	var exporter sdktrace.SpanExporter
+	switch exporterName {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s exporter: %w", exporterName, err)
+	}
+
+	// Later detectors win, so OTEL_SERVICE_NAME overrides service
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	evaluations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ruleengine_evaluations_total",
+		Help: "Track points evaluated against the geofences.",
+	})
+
+	evaluationErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ruleengine_evaluation_errors_total",
+		Help: "Track points whose evaluation failed and was retried with the batch.",
+	})
+
+	eventsEmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ruleengine_events_emitted_total",
+		Help: "Geofence events published, by event type.",
+	}, []string{"event_type"})
+)
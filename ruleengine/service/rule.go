@@ -3,30 +3,56 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/lai/logistics/pkg/kafkaconsumer"
+	"github.com/lai/logistics/pkg/tracing"
 	"github.com/lai/logistics/ruleengine/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/lai/logistics/ruleengine/service")
+
+// EventPublisher publishes geofence events. *EventProducer publishes them to
+// Kafka.
+type EventPublisher interface {
+	Publish(ctx context.Context, evt GeofenceEvent) error
+}
+
 type RuleEngine struct {
-	queries  *db.Queries
-	producer *EventProducer
+	queries  db.Querier
+	producer EventPublisher
 }
 
-func NewRuleEngine(queries *db.Queries, producer *EventProducer) *RuleEngine {
+func NewRuleEngine(queries db.Querier, producer EventPublisher) *RuleEngine {
 	return &RuleEngine{queries: queries, producer: producer}
 }
 
-func (e *RuleEngine) EvaluateBatch(ctx context.Context, points []TrackPoint) {
-	for _, p := range points {
-		if err := e.evaluatePoint(ctx, p); err != nil {
-			slog.Error("evaluate point failed",
-				"container_id", p.ContainerID,
-				"error", err,
-			)
+// EvaluateBatch evaluates points in order and stops at the first failure,
+// so a container's later points are never evaluated ahead of an earlier one.
+// Re-evaluating the points before it on retry is harmless: their states are
+// stored and emit no second event.
+//
+// Each point is evaluated in the trace it was published with, when ctx
+// comes from the Kafka consumer.
+func (e *RuleEngine) EvaluateBatch(ctx context.Context, points []TrackPoint) error {
+	for i, p := range points {
+		pointCtx, span := tracer.Start(kafkaconsumer.ItemContext(ctx, i), "evaluate",
+			trace.WithAttributes(attribute.String("container.id", p.ContainerID)),
+		)
+		err := e.evaluatePoint(pointCtx, p)
+		tracing.End(span, err)
+		if err != nil {
+			evaluationErrors.Inc()
+			return fmt.Errorf("evaluate point of %s: %w", p.ContainerID, err)
 		}
+		evaluations.Inc()
 	}
+	return nil
 }
 
 func (e *RuleEngine) evaluatePoint(ctx context.Context, p TrackPoint) error {
@@ -59,8 +85,7 @@ func (e *RuleEngine) evaluatePoint(ctx context.Context, p TrackPoint) error {
 			err = nil
 		}
 		if err != nil {
-			slog.Error("get state failed", "error", err)
-			continue
+			return fmt.Errorf("get state: %w", err)
 		}
 
 		if !wasInside {
@@ -74,21 +99,25 @@ func (e *RuleEngine) evaluatePoint(ctx context.Context, p TrackPoint) error {
 				Lon:          p.Lon,
 				Timestamp:    p.Timestamp,
 			}
-			if pubErr := e.producer.Publish(ctx, evt); pubErr != nil {
-				slog.Error("publish enter event failed", "error", pubErr)
+			// The state is only stored once the event is acknowledged. A
+			// failed publish fails the batch, which is retried and finally
+			// dead-lettered, so the enter is never silently lost.
+			if err := e.producer.Publish(ctx, evt); err != nil {
+				return fmt.Errorf("publish enter event: %w", err)
 			}
+			eventsEmitted.WithLabelValues(evt.EventType).Inc()
 			slog.Info("geofence enter",
 				"container_id", p.ContainerID,
 				"geofence", gf.Name,
 			)
 		}
 
-		if upsertErr := e.queries.UpsertState(ctx, db.UpsertStateParams{
+		if err := e.queries.UpsertState(ctx, db.UpsertStateParams{
 			ContainerID: p.ContainerID,
 			GeofenceID:  gf.ID,
 			Inside:      true,
-		}); upsertErr != nil {
-			slog.Error("upsert state failed", "error", upsertErr)
+		}); err != nil {
+			return fmt.Errorf("upsert state: %w", err)
 		}
 	}
 
@@ -113,20 +142,22 @@ func (e *RuleEngine) evaluatePoint(ctx context.Context, p TrackPoint) error {
 				Lon:          p.Lon,
 				Timestamp:    p.Timestamp,
 			}
-			if pubErr := e.producer.Publish(ctx, evt); pubErr != nil {
-				slog.Error("publish exit event failed", "error", pubErr)
+			// Same as for enter: the state follows the acknowledged event
+			if err := e.producer.Publish(ctx, evt); err != nil {
+				return fmt.Errorf("publish exit event: %w", err)
 			}
+			eventsEmitted.WithLabelValues(evt.EventType).Inc()
 			slog.Info("geofence exit",
 				"container_id", p.ContainerID,
 				"geofence", s.Name,
 			)
 
-			if upsertErr := e.queries.UpsertState(ctx, db.UpsertStateParams{
+			if err := e.queries.UpsertState(ctx, db.UpsertStateParams{
 				ContainerID: p.ContainerID,
 				GeofenceID:  s.GeofenceID,
 				Inside:      false,
-			}); upsertErr != nil {
-				slog.Error("upsert state failed", "error", upsertErr)
+			}); err != nil {
+				return fmt.Errorf("upsert state: %w", err)
 			}
 		}
 	}
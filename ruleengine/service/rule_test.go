@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/lai/logistics/pkg/dlq"
+	"github.com/lai/logistics/pkg/kafkaconsumer"
+	"github.com/lai/logistics/ruleengine/db"
+	"github.com/segmentio/kafka-go"
+)
+
+var depot = pgtype.UUID{Bytes: [16]byte{1}, Valid: true}
+
+// fakeQuerier implements db.Querier with the geofences a point is inside and
+// the states stored so far.
+type fakeQuerier struct {
+	containing []db.FindContainingGeofencesRow
+
+	mu      sync.Mutex
+	inside  map[pgtype.UUID]bool
+	upserts []db.UpsertStateParams
+}
+
+func (q *fakeQuerier) FindContainingGeofences(context.Context, db.FindContainingGeofencesParams) ([]db.FindContainingGeofencesRow, error) {
+	return q.containing, nil
+}
+
+func (q *fakeQuerier) GetInsideStates(context.Context, string) ([]db.GetInsideStatesRow, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var rows []db.GetInsideStatesRow
+	for id, inside := range q.inside {
+		if inside {
+			rows = append(rows, db.GetInsideStatesRow{GeofenceID: id, Name: "Depot"})
+		}
+	}
+	return rows, nil
+}
+
+func (q *fakeQuerier) GetState(_ context.Context, arg db.GetStateParams) (db.GetStateRow, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	inside, ok := q.inside[arg.GeofenceID]
+	if !ok {
+		return db.GetStateRow{}, pgx.ErrNoRows
+	}
+	return db.GetStateRow{Inside: inside}, nil
+}
+
+func (q *fakeQuerier) UpsertState(_ context.Context, arg db.UpsertStateParams) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inside == nil {
+		q.inside = make(map[pgtype.UUID]bool)
+	}
+	q.inside[arg.GeofenceID] = arg.Inside
+	q.upserts = append(q.upserts, arg)
+	return nil
+}
+
+func (q *fakeQuerier) upsertCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.upserts)
+}
+
+// fakePublisher fails every publish while err is set.
+type fakePublisher struct {
+	err error
+
+	mu       sync.Mutex
+	attempts int
+	events   []GeofenceEvent
+}
+
+func (p *fakePublisher) Publish(_ context.Context, evt GeofenceEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, evt)
+	return nil
+}
+
+func (p *fakePublisher) attemptCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts
+}
+
+// fakeReader serves queued messages and records commits.
+type fakeReader struct {
+	msgs chan kafka.Message
+
+	mu        sync.Mutex
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func (r *fakeReader) commitCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.committed)
+}
+
+type discardDeadLetterer struct{}
+
+func (discardDeadLetterer) Publish(context.Context, dlq.Reason, error, int, ...kafka.Message) error {
+	return nil
+}
+
+func (discardDeadLetterer) Close() error { return nil }
+
+func TestRuleEngine_FailedPublishStoresNoState(t *testing.T) {
+	tests := []struct {
+		name   string
+		q      *fakeQuerier
+		inside bool // stored state once the publish succeeds
+	}{
+		{
+			name:   "enter",
+			q:      &fakeQuerier{containing: []db.FindContainingGeofencesRow{{ID: depot, Name: "Depot"}}},
+			inside: true,
+		},
+		{
+			name:   "exit",
+			q:      &fakeQuerier{inside: map[pgtype.UUID]bool{depot: true}},
+			inside: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{err: errors.New("kafka unavailable")}
+			e := NewRuleEngine(tt.q, pub)
+			points := []TrackPoint{{ContainerID: "CSQU3054383", Lat: 51.9, Lon: 4.4}}
+
+			if err := e.EvaluateBatch(context.Background(), points); err == nil {
+				t.Fatal("expected error")
+			}
+			if n := tt.q.upsertCount(); n != 0 {
+				t.Fatalf("got %d states stored after failed publish, want 0", n)
+			}
+
+			pub.err = nil
+			if err := e.EvaluateBatch(context.Background(), points); err != nil {
+				t.Fatal(err)
+			}
+			if len(pub.events) != 1 || pub.events[0].EventType != tt.name {
+				t.Errorf("got events %+v, want one %s", pub.events, tt.name)
+			}
+			if len(tt.q.upserts) != 1 || tt.q.upserts[0].Inside != tt.inside {
+				t.Errorf("got upserts %+v, want inside=%v", tt.q.upserts, tt.inside)
+			}
+		})
+	}
+}
+
+func TestRuleEngine_FailedPublishLeavesBatchUncommitted(t *testing.T) {
+	q := &fakeQuerier{containing: []db.FindContainingGeofencesRow{{ID: depot, Name: "Depot"}}}
+	pub := &fakePublisher{err: errors.New("kafka unavailable")}
+	engine := NewRuleEngine(q, pub)
+
+	tp := TrackPoint{ContainerID: "CSQU3054383", Lat: 51.9, Lon: 4.4, Timestamp: time.Now()}
+	data, err := contracts.Marshal(contracts.TypeTrackPoint, "test", tp.Timestamp, contracts.TraceContext{}, tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &fakeReader{msgs: make(chan kafka.Message, 1)}
+	reader.msgs <- kafka.Message{Offset: 7, Value: data}
+
+	c := kafkaconsumer.NewWithReader(kafkaconsumer.Config{
+		BatchSize:       1,
+		BatchTimeout:    time.Millisecond,
+		MaxAttempts:     1000,
+		RetryBackoff:    time.Millisecond,
+		MaxRetryBackoff: time.Millisecond,
+		ShutdownTimeout: 50 * time.Millisecond,
+	}, reader, discardDeadLetterer{}, kafkaconsumer.Envelope[TrackPoint](contracts.TypeTrackPoint), engine.EvaluateBatch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pub.attemptCount() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := reader.commitCount(); n != 0 {
+		t.Errorf("got %d commits, want the batch uncommitted", n)
+	}
+	if n := q.upsertCount(); n != 0 {
+		t.Errorf("got %d states stored, want none", n)
+	}
+}
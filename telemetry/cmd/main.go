@@ -2,16 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/lai/logistics/pkg/tracing"
+	"github.com/lai/logistics/telemetry/mqttbridge"
 	"github.com/lai/logistics/telemetry/service"
+	"github.com/lai/logistics/telemetry/tracker"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
@@ -20,34 +29,163 @@ func main() {
 		Level: slog.LevelInfo,
 	}))
 	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "telemetry")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
 	brokers := strings.Split(getenv("KAFKA_BROKERS", "kafka.app.svc.cluster.local:9092"), ",")
 	topic := getenv("KAFKA_TOPIC", "container.telemetry")
 	addr := getenv("LISTEN_ADDR", ":8080")
 
-	producer := service.NewKafkaProducer(brokers, topic)
+	// The spool only sees failed writes, which async delivery never returns
+	spoolDir := getenv("SPOOL_DIR", "")
+	defaultMode := service.DeliveryAsync
+	if spoolDir != "" {
+		defaultMode = service.DeliverySync
+	}
+	mode, err := service.ParseDeliveryMode(getenv("KAFKA_DELIVERY", string(defaultMode)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	producer := service.NewKafkaProducer(service.KafkaProducerConfig{
+		Brokers:     brokers,
+		Topic:       topic,
+		Mode:        mode,
+		MaxInFlight: getenvInt("PRODUCER_MAX_IN_FLIGHT", 10000),
+		OnDelivery: func(messages int, err error) {
+			if err != nil {
+				slog.Error("kafka delivery failed", "error", err, "messages", messages, "mode", mode)
+			}
+		},
+	})
 	defer producer.Close()
 
-	handler := service.NewHandler(producer)
+	// Optional disk spool that keeps accepted points through a Kafka outage
+	var out service.Producer = producer
+	var spool *service.Spool
+	if spoolDir != "" {
+		spool, err = service.OpenSpool(spoolDir, int64(getenvInt("SPOOL_MAX_MB", 1024))<<20)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer spool.Close()
+		if out, err = service.NewSpoolingProducer(producer, spool); err != nil {
+			log.Fatalf("SPOOL_DIR with KAFKA_DELIVERY=%s: %v", mode, err)
+		}
+		points, _ := spool.Depth()
+		slog.Info("spool enabled", "dir", spoolDir, "pending", points)
+	}
+
+	idMode, err := service.ParseContainerIDMode(getenv("CONTAINER_ID_MODE", "lenient"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []service.PipelineOption{service.WithContainerIDMode(idMode)}
+	if dedup := newDedupStore(); dedup != nil {
+		opts = append(opts, service.WithDedup(dedup))
+	}
+	switch check := getenv("PLAUSIBILITY", "quarantine"); check {
+	case "quarantine", "reject":
+		plausibility := service.NewPlausibility(service.PlausibilityConfig{
+			MaxSpeed:      getenvFloat("PLAUSIBILITY_MAX_SPEED", service.DefaultPlausibilityConfig.MaxSpeed),
+			MaxFutureSkew: getenvDuration("PLAUSIBILITY_MAX_FUTURE_SKEW", service.DefaultPlausibilityConfig.MaxFutureSkew),
+		})
+		var quarantine service.Producer
+		if check == "quarantine" {
+			qp := service.NewKafkaProducer(service.KafkaProducerConfig{
+				Brokers: brokers,
+				Topic:   getenv("KAFKA_QUARANTINE_TOPIC", "container.telemetry.quarantine"),
+				Mode:    mode,
+			})
+			defer qp.Close()
+			quarantine = qp
+		}
+		slog.Info("plausibility filter enabled", "mode", check)
+		opts = append(opts, service.WithPlausibility(plausibility, quarantine))
+	case "off":
+	default:
+		log.Fatalf("unknown PLAUSIBILITY %q", check)
+	}
+	opts = append(opts, service.WithRateLimit(service.NewRateLimiter(service.RateLimitConfig{
+		DeviceRate:  getenvFloat("DEVICE_RATE_LIMIT", 1),
+		DeviceBurst: getenvInt("DEVICE_RATE_BURST", 10),
+		GlobalRate:  getenvFloat("GLOBAL_RATE_LIMIT", 20000),
+		GlobalBurst: getenvInt("GLOBAL_RATE_BURST", 20000),
+	})))
+	// Every ingest source shares the pipeline, and with it the rate limits
+	// and dedup window
+	pipeline := service.NewPipeline(out, opts...)
+	handler := service.NewHandler(pipeline)
 
 	mux := http.NewServeMux()
-	mux.Handle("/api/track", handler)
+	mux.HandleFunc("/health", healthHandler(spool))
+	mux.Handle("/metrics", promhttp.Handler())
+	keys := newKeyStore()
+	switch mode := getenv("AUTH_MODE", "off"); mode {
+	case "required":
+		slog.Info("device authentication enabled")
+		mux.Handle("/api/track", service.NewAuthenticator(keys, newNonceStore()).Middleware(handler))
+	case "off":
+		mux.Handle("/api/track", handler)
+	default:
+		log.Fatalf("unknown AUTH_MODE %q", mode)
+	}
+	if token := getenv("ADMIN_TOKEN", ""); token != "" {
+		mux.Handle("/admin/", service.NewAdminHandler(keys, token))
+	}
 	srv := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
+		Addr: addr,
+		// Starts the trace of every upload, or continues the caller's
+		Handler: otelhttp.NewHandler(mux, "telemetry", otelhttp.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/health" && r.URL.Path != "/metrics"
+		})),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Raw TCP/UDP listeners for legacy trackers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startTrackerListeners(ctx, pipeline)
+	if sp, ok := out.(*service.SpoolingProducer); ok {
+		go sp.Run(ctx)
+	}
+
+	// Optional MQTT bridge for IoT seals that don't speak HTTP
+	if broker := getenv("MQTT_BROKER", ""); broker != "" {
+		hostname, _ := os.Hostname()
+		bridge := mqttbridge.New(mqttbridge.Config{
+			BrokerURL: broker,
+			ClientID:  getenv("MQTT_CLIENT_ID", "telemetry-"+hostname),
+			Username:  getenv("MQTT_USERNAME", ""),
+			Password:  getenv("MQTT_PASSWORD", ""),
+			Topics:    strings.Split(getenv("MQTT_TOPICS", "containers/+/position"), ","),
+		}, pipeline)
+		if err := bridge.Start(); err != nil {
+			log.Fatal(err)
+		}
+		defer bridge.Close()
+	}
+
 	done := make(chan struct{})
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 		<-sigint
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+		cancel()
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer shutdownCancel()
 
-		srv.Shutdown(ctx)
+		srv.Shutdown(shutdownCtx)
 		close(done)
 	}()
 
@@ -60,9 +198,136 @@ func main() {
 	slog.Info("shutdown complete")
 }
 
+// healthHandler reports liveness and, with a spool, how much is waiting
+// for Kafka. A non-empty spool is not unhealthy: restarting would not help.
+func healthHandler(spool *service.Spool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]any{"status": "ok"}
+		if spool != nil {
+			points, bytes := spool.Depth()
+			resp["spool_depth"] = points
+			resp["spool_bytes"] = bytes
+			if points > 0 {
+				resp["status"] = "spooling"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
 func getenv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
+
+func getenvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getenvFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// newDedupStore picks the dedup backend from DEDUP_BACKEND (memory, redis or off).
+func newDedupStore() service.DedupStore {
+	window := getenvDuration("DEDUP_WINDOW", 10*time.Minute)
+	switch backend := getenv("DEDUP_BACKEND", "memory"); backend {
+	case "off":
+		return nil
+	case "redis":
+		slog.Info("dedup enabled", "backend", backend, "window", window)
+		return service.NewRedisDedupStore(newRedisClient(), window)
+	default:
+		size := getenvInt("DEDUP_CACHE_SIZE", 100000)
+		slog.Info("dedup enabled", "backend", "memory", "window", window, "size", size)
+		return service.NewMemoryDedupStore(size, window)
+	}
+}
+
+// newNonceStore keeps HMAC nonces in the dedup backend, so replicas behind a
+// load balancer share them. Replay protection can't be turned off, so with
+// DEDUP_BACKEND=off they are kept in memory.
+func newNonceStore() service.DedupStore {
+	if getenv("DEDUP_BACKEND", "memory") == "redis" {
+		return service.NewRedisDedupStore(newRedisClient(), service.NonceWindow)
+	}
+	return service.NewMemoryDedupStore(getenvInt("DEDUP_CACHE_SIZE", 100000), service.NonceWindow)
+}
+
+// newKeyStore picks where device keys live from AUTH_BACKEND (redis or memory).
+func newKeyStore() service.KeyStore {
+	if getenv("AUTH_BACKEND", "redis") == "memory" {
+		return service.NewMemoryKeyStore()
+	}
+	return service.NewRedisKeyStore(newRedisClient())
+}
+
+func newRedisClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: getenv("REDIS_ADDR", "redis.redis.svc.cluster.local:6379"),
+	})
+}
+
+// startTrackerListeners starts a TCP/UDP listener for every configured
+// <PROTOCOL>_TCP_ADDR / <PROTOCOL>_UDP_ADDR. Unset addresses are disabled.
+func startTrackerListeners(ctx context.Context, pipeline *service.Pipeline) {
+	devices, err := tracker.ParseDeviceMap(getenv("TRACKER_DEVICES", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	resolve := tracker.StaticResolver(devices)
+
+	for _, name := range []string{"nmea", "gt06"} {
+		protocol, err := tracker.ProtocolByName(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv := tracker.NewServer(protocol, pipeline, resolve)
+		prefix := strings.ToUpper(name)
+
+		if addr := getenv(prefix+"_TCP_ADDR", ""); addr != "" {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go func() {
+				if err := srv.ServeTCP(ctx, ln); err != nil {
+					slog.Error("tracker TCP listener stopped", "protocol", name, "error", err)
+				}
+			}()
+		}
+		if addr := getenv(prefix+"_UDP_ADDR", ""); addr != "" {
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go func() {
+				if err := srv.ServeUDP(ctx, conn); err != nil {
+					slog.Error("tracker UDP listener stopped", "protocol", name, "error", err)
+				}
+			}()
+		}
+	}
+}
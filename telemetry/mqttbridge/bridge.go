@@ -0,0 +1,211 @@
+// Package mqttbridge subscribes to container positions published over MQTT
+// and forwards them to Kafka through the telemetry ingest Pipeline.
+package mqttbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lai/logistics/pkg/iso6346"
+	"github.com/lai/logistics/telemetry/service"
+)
+
+// Only QoS 1 gives us an acknowledgement to withhold until Kafka has the point.
+const qos = 1
+
+// Backoff between attempts to write a message while Kafka is failing.
+const (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+// Config holds the MQTT connection settings.
+type Config struct {
+	BrokerURL string // e.g. tcp://mosquitto:1883
+	ClientID  string
+	Username  string
+	Password  string
+	// Topics are subscription filters. The first "+" segment of each filter
+	// is the container ID, e.g. "containers/+/position". Shared
+	// subscriptions ("$share/telemetry/containers/+/position") spread the
+	// load over telemetry replicas.
+	Topics []string
+	// WriteTimeout bounds one attempt to write a message to Kafka. Failed
+	// attempts are retried until the bridge is closed.
+	WriteTimeout time.Duration
+}
+
+// Bridge forwards MQTT messages to a Pipeline.
+type Bridge struct {
+	cfg      Config
+	pipeline *service.Pipeline
+	client   mqtt.Client
+	// stop ends the retries of a message being handled when the bridge
+	// closes, leaving it unacknowledged for the next session.
+	stop   context.Context
+	cancel context.CancelFunc
+}
+
+// New creates a bridge; call Start to connect.
+func New(cfg Config, pipeline *service.Pipeline) *Bridge {
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	b := &Bridge{cfg: cfg, pipeline: pipeline}
+	b.stop, b.cancel = context.WithCancel(context.Background())
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		// Persistent session: messages not yet acknowledged are redelivered
+		// after a reconnect instead of being lost.
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("mqtt connection lost", "error", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// Start connects to the broker. Subscriptions are (re)established on every
+// connect.
+func (b *Bridge) Start() error {
+	token := b.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+// Close stops retrying writes and disconnects, waiting briefly for in-flight
+// handlers. Messages not yet written stay unacknowledged in the session.
+func (b *Bridge) Close() {
+	b.cancel()
+	b.client.Disconnect(250)
+}
+
+func (b *Bridge) subscribe(c mqtt.Client) {
+	for _, filter := range b.cfg.Topics {
+		idx := containerSegment(filter)
+		token := c.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
+			b.handle(msg, idx)
+		})
+		token.Wait()
+		if err := token.Error(); err != nil {
+			slog.Error("mqtt subscribe failed", "topic", filter, "error", err)
+			continue
+		}
+		slog.Info("mqtt subscribed", "topic", filter)
+	}
+}
+
+// handle acknowledges a message only once every accepted point in it was
+// written to Kafka. Malformed payloads and rejected points are acknowledged
+// too: redelivering them would never succeed. The broker only redelivers
+// after a reconnect, so over a rate limit or while Kafka is failing, handle
+// waits and retries until the write succeeds or the bridge closes, which
+// holds back the messages queued behind this one.
+func (b *Bridge) handle(msg mqtt.Message, containerIdx int) {
+	points, err := decode(msg.Topic(), msg.Payload(), containerIdx)
+	if err != nil {
+		slog.Warn("mqtt message rejected", "topic", msg.Topic(), "error", err)
+		service.PointsRejected.WithLabelValues(service.SourceMQTT, "invalid_message").Inc()
+		msg.Ack()
+		return
+	}
+
+	backoff := retryBackoff
+	for {
+		ctx, cancel := context.WithTimeout(b.stop, b.cfg.WriteTimeout)
+		result, err := b.pipeline.Ingest(ctx, service.SourceMQTT, points)
+		cancel()
+		if err == nil {
+			for _, r := range result.Rejected {
+				slog.Warn("mqtt point rejected", "topic", msg.Topic(), "index", r.Index, "error", r.Error)
+			}
+			msg.Ack()
+			return
+		}
+
+		wait := backoff
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			wait = limited.Wait
+		} else {
+			slog.Error("kafka write failed, retrying",
+				"error", err,
+				"topic", msg.Topic(),
+				"count", len(points),
+			)
+			backoff = min(backoff*2, maxRetryBackoff)
+		}
+		select {
+		case <-b.stop.Done():
+			// No ack: the session redelivers the message on the next connect
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// decode parses a JSON TrackPoint or array of TrackPoints. The container ID
+// comes from the topic; a payload naming a different container is rejected.
+func decode(topic string, payload []byte, containerIdx int) ([]service.TrackPoint, error) {
+	var points []service.TrackPoint
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		if err := json.Unmarshal(payload, &points); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		var tp service.TrackPoint
+		if err := json.Unmarshal(payload, &tp); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		points = []service.TrackPoint{tp}
+	}
+
+	topicID := ""
+	if segments := strings.Split(topic, "/"); containerIdx >= 0 && containerIdx < len(segments) {
+		topicID = segments[containerIdx]
+	}
+	for i := range points {
+		tp := &points[i]
+		switch {
+		case topicID == "":
+		case tp.ContainerID == "":
+			tp.ContainerID = topicID
+		case iso6346.Normalize(tp.ContainerID) != iso6346.Normalize(topicID):
+			return nil, fmt.Errorf("container_id %q does not match topic", tp.ContainerID)
+		}
+	}
+	return points, nil
+}
+
+// containerSegment returns the index of the first "+" in a topic filter,
+// ignoring a "$share/<group>/" prefix, or -1 if there is none.
+func containerSegment(filter string) int {
+	if strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) < 3 {
+			return -1
+		}
+		filter = parts[2]
+	}
+	for i, seg := range strings.Split(filter, "/") {
+		if seg == "+" {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,257 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+// mockProducer implements service.Producer for testing.
+type mockProducer struct {
+	mu      sync.Mutex
+	written []service.TrackPoint
+	err     error
+}
+
+func (m *mockProducer) Write(ctx context.Context, tp service.TrackPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, tp)
+	return nil
+}
+
+func (m *mockProducer) WriteBatch(ctx context.Context, points []service.TrackPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, points...)
+	return nil
+}
+
+func (m *mockProducer) Close() error {
+	return nil
+}
+
+func (m *mockProducer) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *mockProducer) points() []service.TrackPoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]service.TrackPoint(nil), m.written...)
+}
+
+// startBroker runs an in-process MQTT broker so the bridge can be tested
+// offline. It returns the broker and its tcp:// URL.
+func startBroker(t *testing.T) (*mqttserver.Server, string) {
+	t.Helper()
+
+	// Reserve a free port for the listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	broker := mqttserver.New(&mqttserver.Options{InlineClient: true})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	go broker.Serve()
+	t.Cleanup(func() { broker.Close() })
+	return broker, "tcp://" + addr
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func payload(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBridge_ForwardsWithContainerFromTopic(t *testing.T) {
+	broker, url := startBroker(t)
+	mock := &mockProducer{}
+
+	b := New(Config{BrokerURL: url, ClientID: "bridge-test", Topics: []string{"containers/+/position"}}, service.NewPipeline(mock))
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	time.Sleep(100 * time.Millisecond) // let the subscription settle
+
+	ts := time.Now().UTC()
+	// Container ID omitted: taken from the topic
+	broker.Publish("containers/MSKU1234567/position",
+		payload(t, map[string]any{"lat": 51.9, "lon": 4.4, "timestamp": ts, "speed": 3}), false, 1)
+	// Spoofed container ID: rejected
+	broker.Publish("containers/MSKU1234567/position",
+		payload(t, map[string]any{"container_id": "OTHER", "lat": 51.9, "lon": 4.4, "timestamp": ts}), false, 1)
+	// Same container in printed form: normalized, not a spoof
+	broker.Publish("containers/MSKU1234567/position",
+		payload(t, map[string]any{"container_id": "msku 123456 7", "lat": 51.9, "lon": 4.4, "timestamp": ts.Add(time.Second)}), false, 1)
+	// Invalid point: rejected
+	broker.Publish("containers/TCLU7654321/position",
+		payload(t, map[string]any{"lat": 95, "lon": 4.4, "timestamp": ts}), false, 1)
+	// Batch
+	broker.Publish("containers/TCLU7654321/position",
+		payload(t, []map[string]any{
+			{"lat": 51.91, "lon": 4.41, "timestamp": ts},
+			{"lat": 51.92, "lon": 4.42, "timestamp": ts.Add(time.Second)},
+		}), false, 1)
+
+	waitFor(t, func() bool { return len(mock.points()) >= 4 })
+	time.Sleep(50 * time.Millisecond)
+
+	points := mock.points()
+	if len(points) != 4 {
+		t.Fatalf("got %d written points, want 4", len(points))
+	}
+	want := []string{"MSKU1234567", "MSKU1234567", "TCLU7654321", "TCLU7654321"}
+	for i, tp := range points {
+		if tp.ContainerID != want[i] {
+			t.Errorf("point %d: got ContainerID %q, want %q", i, tp.ContainerID, want[i])
+		}
+	}
+}
+
+func TestBridge_AppliesPipelineChecks(t *testing.T) {
+	broker, url := startBroker(t)
+	mock := &mockProducer{}
+	pipeline := service.NewPipeline(mock,
+		service.WithContainerIDMode(service.ContainerIDStrict),
+		service.WithDedup(service.NewMemoryDedupStore(100, time.Minute)),
+	)
+
+	b := New(Config{BrokerURL: url, ClientID: "bridge-checks", Topics: []string{"containers/+/position"}}, pipeline)
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ts := time.Now().UTC()
+	point := map[string]any{"lat": 51.9, "lon": 4.4, "timestamp": ts, "point_id": "1"}
+	// Wrong check digit (should be 5): rejected in strict mode
+	broker.Publish("containers/MSKU1234567/position", payload(t, point), false, 1)
+	// Published once, the retry is a duplicate
+	broker.Publish("containers/MSKU1234565/position", payload(t, point), false, 1)
+	broker.Publish("containers/MSKU1234565/position", payload(t, point), false, 1)
+
+	waitFor(t, func() bool { return len(mock.points()) >= 1 })
+	time.Sleep(100 * time.Millisecond)
+
+	points := mock.points()
+	if len(points) != 1 || points[0].ContainerID != "MSKU1234565" {
+		t.Errorf("got written %+v, want one point for MSKU1234565", points)
+	}
+}
+
+func TestBridge_RetriesUntilKafkaWriteSucceeds(t *testing.T) {
+	broker, url := startBroker(t)
+	mock := &mockProducer{err: errors.New("kafka unavailable")}
+
+	b := New(Config{BrokerURL: url, ClientID: "bridge-retry", Topics: []string{"containers/+/position"}}, service.NewPipeline(mock))
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ts := time.Now().UTC()
+	broker.Publish("containers/MSKU1234567/position",
+		payload(t, map[string]any{"lat": 51.9, "lon": 4.4, "timestamp": ts}), false, 1)
+	broker.Publish("containers/TCLU7654321/position",
+		payload(t, map[string]any{"lat": 51.9, "lon": 4.4, "timestamp": ts}), false, 1)
+	time.Sleep(300 * time.Millisecond)
+
+	// Kafka recovers while the bridge stays connected: both messages get
+	// through and are acknowledged
+	mock.setErr(nil)
+	waitFor(t, func() bool { return len(mock.points()) == 2 })
+	waitFor(t, func() bool {
+		cl, ok := broker.Clients.Get("bridge-retry")
+		return ok && cl.State.Inflight.Len() == 0
+	})
+}
+
+func TestBridge_RedeliversUnwrittenAfterRestart(t *testing.T) {
+	broker, url := startBroker(t)
+	failing := &mockProducer{err: errors.New("kafka unavailable")}
+	cfg := Config{BrokerURL: url, ClientID: "bridge-redeliver", Topics: []string{"containers/+/position"}}
+
+	b := New(cfg, service.NewPipeline(failing))
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	broker.Publish("containers/MSKU1234567/position",
+		payload(t, map[string]any{"lat": 51.9, "lon": 4.4, "timestamp": time.Now().UTC()}), false, 1)
+	time.Sleep(200 * time.Millisecond)
+	// Still retrying when closed: the message is left unacknowledged
+	b.Close()
+
+	// Same client ID resumes the persistent session; the unacknowledged
+	// message must come back.
+	working := &mockProducer{}
+	b = New(cfg, service.NewPipeline(working))
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	waitFor(t, func() bool { return len(working.points()) == 1 })
+	if got := working.points()[0].ContainerID; got != "MSKU1234567" {
+		t.Errorf("got ContainerID %q, want %q", got, "MSKU1234567")
+	}
+}
+
+func TestContainerSegment(t *testing.T) {
+	tests := map[string]int{
+		"containers/+/position":                  1,
+		"$share/telemetry/containers/+/position": 1,
+		"fleet/+/containers/+/gps":               1,
+		"containers/#":                           -1,
+	}
+	for filter, want := range tests {
+		if got := containerSegment(filter); got != want {
+			t.Errorf("containerSegment(%q) = %d, want %d", filter, got, want)
+		}
+	}
+}
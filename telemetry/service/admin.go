@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// issueKeyRequest is the body for POST /admin/keys.
+type issueKeyRequest struct {
+	DeviceID     string   `json:"device_id"`
+	ContainerIDs []string `json:"container_ids"`
+}
+
+// issuedKey is returned once on issue; the secret can't be read back later.
+type issuedKey struct {
+	DeviceKey
+	APIKey string `json:"api_key"`
+}
+
+// AdminHandler manages device keys:
+//
+//	POST   /admin/keys      issue a key, the secret is only returned here
+//	GET    /admin/keys      list keys (without secrets)
+//	DELETE /admin/keys/{id} revoke a key
+//
+// Every request must carry "Authorization: Bearer <admin token>".
+type AdminHandler struct {
+	keys  KeyStore
+	token string
+	mux   *http.ServeMux
+}
+
+// NewAdminHandler creates the admin API guarded by token.
+func NewAdminHandler(keys KeyStore, token string) *AdminHandler {
+	h := &AdminHandler{keys: keys, token: token, mux: http.NewServeMux()}
+	h.mux.HandleFunc("POST /admin/keys", h.issue)
+	h.mux.HandleFunc("GET /admin/keys", h.list)
+	h.mux.HandleFunc("DELETE /admin/keys/{id}", h.revoke)
+	return h
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *AdminHandler) issue(w http.ResponseWriter, r *http.Request) {
+	var req issueKeyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	if len(req.ContainerIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "container_ids is required")
+		return
+	}
+
+	key, err := NewDeviceKey(req.DeviceID, req.ContainerIDs)
+	if err != nil {
+		slog.Error("generate device key failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := h.keys.Put(r.Context(), key); err != nil {
+		slog.Error("store device key failed", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+
+	slog.Info("device key issued", "key_id", key.ID, "device_id", key.DeviceID, "containers", len(key.ContainerIDs))
+	writeJSON(w, http.StatusCreated, issuedKey{DeviceKey: key, APIKey: key.ID + "." + key.Secret})
+}
+
+func (h *AdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		slog.Error("list device keys failed", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
+	}
+	for i := range keys {
+		keys[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (h *AdminHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := h.keys.Delete(r.Context(), id)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		slog.Error("revoke device key failed", "error", err, "key_id", id)
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+	default:
+		slog.Info("device key revoked", "key_id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
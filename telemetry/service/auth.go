@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device credentials are sent in their own headers, so they don't collide
+// with the user JWT the gateway forwards in Authorization.
+const (
+	headerAPIKey    = "X-API-Key"   // "<key id>.<secret>"
+	headerKeyID     = "X-Key-ID"    // HMAC: key id
+	headerTimestamp = "X-Timestamp" // HMAC: Unix seconds
+	headerNonce     = "X-Nonce"     // HMAC: unique per request
+	headerSignature = "X-Signature" // HMAC: hex HMAC-SHA256
+)
+
+const (
+	// DefaultMaxClockSkew bounds how old a signed request may be.
+	DefaultMaxClockSkew = 5 * time.Minute
+	// NonceWindow is how long a nonce must be remembered: a timestamp may
+	// be up to DefaultMaxClockSkew ahead, and is accepted until it is as
+	// far behind.
+	NonceWindow = 2 * DefaultMaxClockSkew
+
+	minNonceLen = 8
+	maxNonceLen = 64
+)
+
+type deviceKeyContextKey struct{}
+
+// DeviceFromContext returns the key that authenticated the request, if any.
+func DeviceFromContext(ctx context.Context) (DeviceKey, bool) {
+	key, ok := ctx.Value(deviceKeyContextKey{}).(DeviceKey)
+	return key, ok
+}
+
+// Authenticator verifies device credentials before a request reaches the
+// Handler. Two schemes are accepted:
+//
+//   - API key: X-API-Key: <key id>.<secret>
+//   - HMAC: X-Key-ID, X-Timestamp, X-Nonce and X-Signature, where the signature is
+//     hex(HMAC-SHA256(secret, method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + body))
+//     over the body exactly as sent (before decompression)
+//
+// HMAC keeps the secret off the wire, for devices that can't pin TLS. A
+// nonce is accepted once per key, so a captured request can't be replayed
+// while its timestamp is still fresh.
+type Authenticator struct {
+	keys    KeyStore
+	nonces  DedupStore
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewAuthenticator checks credentials against keys. nonces remembers the
+// nonces of signed requests and must keep them for at least NonceWindow.
+func NewAuthenticator(keys KeyStore, nonces DedupStore) *Authenticator {
+	return &Authenticator{keys: keys, nonces: nonces, maxSkew: DefaultMaxClockSkew, now: time.Now}
+}
+
+var (
+	errMissingCredentials = errors.New("missing device credentials")
+	errInvalidCredentials = errors.New("invalid device credentials")
+	errReplayedRequest    = fmt.Errorf("%w: nonce already used", errInvalidCredentials)
+)
+
+// Middleware rejects unauthenticated requests with 401 and stores the
+// DeviceKey in the request context for the Handler's container check.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := a.authenticate(r)
+		if err != nil {
+			if errors.Is(err, errMissingCredentials) || errors.Is(err, errInvalidCredentials) {
+				slog.Warn("device authentication failed",
+					"error", err,
+					"remote", r.RemoteAddr,
+					"request_id", r.Header.Get("X-Request-ID"),
+				)
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			slog.Error("device credential check failed", "error", err)
+			writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deviceKeyContextKey{}, key)))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (DeviceKey, error) {
+	if r.Header.Get(headerSignature) != "" {
+		return a.verifySignature(r)
+	}
+	apiKey := r.Header.Get(headerAPIKey)
+	if apiKey == "" {
+		return DeviceKey{}, errMissingCredentials
+	}
+	id, secret, ok := strings.Cut(apiKey, ".")
+	if !ok {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	key, err := a.lookup(r.Context(), id)
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(key.Secret)) != 1 {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	return key, nil
+}
+
+func (a *Authenticator) verifySignature(r *http.Request) (DeviceKey, error) {
+	ts, err := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+	if err != nil {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	if skew := a.now().Sub(time.Unix(ts, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	nonce := r.Header.Get(headerNonce)
+	if len(nonce) < minNonceLen || len(nonce) > maxNonceLen {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	sig, err := hex.DecodeString(r.Header.Get(headerSignature))
+	if err != nil {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	key, err := a.lookup(r.Context(), r.Header.Get(headerKeyID))
+	if err != nil {
+		return DeviceKey{}, err
+	}
+
+	// The handler still needs the body, so buffer it (compressed size is
+	// bounded by the same limit as the decoded batch)
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxBatchBytes))
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !hmac.Equal(sig, Sign(key.Secret, r.Method, r.URL.Path, ts, nonce, body)) {
+		return DeviceKey{}, errInvalidCredentials
+	}
+
+	// Only claimed once the signature holds, so forged requests can't use
+	// up a device's nonces
+	nonceKey := "nonce:" + key.ID + "/" + nonce
+	state, err := a.nonces.Claim(r.Context(), nonceKey)
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	if state != ClaimNew {
+		return DeviceKey{}, errReplayedRequest
+	}
+	// Spent right away, for the whole window rather than PendingClaimTTL
+	if err := a.nonces.Confirm(r.Context(), nonceKey); err != nil {
+		return DeviceKey{}, err
+	}
+	return key, nil
+}
+
+func (a *Authenticator) lookup(ctx context.Context, id string) (DeviceKey, error) {
+	if id == "" {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	key, err := a.keys.Get(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return DeviceKey{}, errInvalidCredentials
+	}
+	return key, err
+}
+
+// Sign computes the X-Signature value (before hex encoding) for a request.
+func Sign(secret, method, path string, timestamp int64, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T, store KeyStore, containerIDs ...string) DeviceKey {
+	t.Helper()
+	key, err := NewDeviceKey("seal-1", containerIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func trackRequest(t *testing.T, points []TrackPoint) *http.Request {
+	t.Helper()
+	body, _ := json.Marshal(points)
+	return httptest.NewRequest(http.MethodPost, "/api/track", bytes.NewReader(body))
+}
+
+func TestAuthenticator_APIKey(t *testing.T) {
+	store := NewMemoryKeyStore()
+	key := newTestKey(t, store, "MSKU1234567")
+	handler := NewAuthenticator(store, NewMemoryDedupStore(100, NonceWindow)).Middleware(NewHandler(NewPipeline(&mockProducer{})))
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		wantStatus int
+	}{
+		{"valid key", key.ID + "." + key.Secret, http.StatusAccepted},
+		{"missing key", "", http.StatusUnauthorized},
+		{"wrong secret", key.ID + ".nope", http.StatusUnauthorized},
+		{"unknown key", "unknown." + key.Secret, http.StatusUnauthorized},
+		{"malformed key", key.Secret, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := trackRequest(t, []TrackPoint{validTrackPoint()})
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeviceKey_Allows(t *testing.T) {
+	key := DeviceKey{ContainerIDs: []string{"MSKU1234565", "csqu 305438 3"}}
+	tests := map[string]bool{
+		"MSKU1234565":   true,
+		"msku 123456 5": true,
+		"CSQU3054383":   true, // issued in printed form
+		"TCLU7654321":   false,
+	}
+	for id, want := range tests {
+		if got := key.Allows(id); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", id, got, want)
+		}
+	}
+	if !(DeviceKey{ContainerIDs: []string{"*"}}).Allows("TCLU7654321") {
+		t.Error("wildcard key does not allow TCLU7654321")
+	}
+}
+
+func TestAuthenticator_HMAC(t *testing.T) {
+	store := NewMemoryKeyStore()
+	key := newTestKey(t, store, "MSKU1234567")
+	auth := NewAuthenticator(store, NewMemoryDedupStore(100, NonceWindow))
+	now := time.Unix(1769162400, 0)
+	auth.now = func() time.Time { return now }
+	mock := &mockProducer{}
+	handler := auth.Middleware(NewHandler(NewPipeline(mock)))
+
+	body, _ := json.Marshal([]TrackPoint{validTrackPoint()})
+	sign := func(ts int64, nonce, secret string) string {
+		return hex.EncodeToString(Sign(secret, http.MethodPost, "/api/track", ts, nonce, body))
+	}
+
+	tests := []struct {
+		name       string
+		ts         int64
+		nonce      string
+		signature  string
+		wantStatus int
+	}{
+		{"valid signature", now.Unix(), "nonce-0001", sign(now.Unix(), "nonce-0001", key.Secret), http.StatusAccepted},
+		{"replayed nonce", now.Unix(), "nonce-0001", sign(now.Unix(), "nonce-0001", key.Secret), http.StatusUnauthorized},
+		{"wrong secret", now.Unix(), "nonce-0002", sign(now.Unix(), "nonce-0002", "other"), http.StatusUnauthorized},
+		{"stale timestamp", now.Add(-time.Hour).Unix(), "nonce-0003", sign(now.Add(-time.Hour).Unix(), "nonce-0003", key.Secret), http.StatusUnauthorized},
+		{"signature for other timestamp", now.Unix(), "nonce-0004", sign(now.Unix()-1, "nonce-0004", key.Secret), http.StatusUnauthorized},
+		{"signature for other nonce", now.Unix(), "nonce-0005", sign(now.Unix(), "nonce-0001", key.Secret), http.StatusUnauthorized},
+		{"missing nonce", now.Unix(), "", sign(now.Unix(), "", key.Secret), http.StatusUnauthorized},
+		{"short nonce", now.Unix(), "n1", sign(now.Unix(), "n1", key.Secret), http.StatusUnauthorized},
+		{"not hex", now.Unix(), "nonce-0006", "zz", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/track", bytes.NewReader(body))
+			req.Header.Set("X-Key-ID", key.ID)
+			req.Header.Set("X-Timestamp", strconv.FormatInt(tt.ts, 10))
+			req.Header.Set("X-Nonce", tt.nonce)
+			req.Header.Set("X-Signature", tt.signature)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// The handler must still see the body the signature was computed over
+	if len(mock.written) != 1 {
+		t.Errorf("got %d written points, want 1", len(mock.written))
+	}
+}
+
+func TestAuthenticator_ForgedRequestKeepsNonce(t *testing.T) {
+	store := NewMemoryKeyStore()
+	key := newTestKey(t, store, "MSKU1234567")
+	handler := NewAuthenticator(store, NewMemoryDedupStore(100, NonceWindow)).Middleware(NewHandler(NewPipeline(&mockProducer{})))
+
+	body, _ := json.Marshal([]TrackPoint{validTrackPoint()})
+	ts := time.Now().Unix()
+	send := func(secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/track", bytes.NewReader(body))
+		req.Header.Set("X-Key-ID", key.ID)
+		req.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+		req.Header.Set("X-Nonce", "nonce-0001")
+		req.Header.Set("X-Signature", hex.EncodeToString(Sign(secret, http.MethodPost, "/api/track", ts, "nonce-0001", body)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A bad signature must not burn the nonce the device is about to use
+	if code := send("forged"); code != http.StatusUnauthorized {
+		t.Fatalf("forged: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := send(key.Secret); code != http.StatusAccepted {
+		t.Errorf("genuine: got status %d, want %d", code, http.StatusAccepted)
+	}
+}
+
+func TestAuthenticator_RejectsSpoofedContainer(t *testing.T) {
+	store := NewMemoryKeyStore()
+	key := newTestKey(t, store, "MSKU1234567")
+	mock := &mockProducer{}
+	handler := NewAuthenticator(store, NewMemoryDedupStore(100, NonceWindow)).Middleware(NewHandler(NewPipeline(mock)))
+
+	spoofed := validTrackPoint()
+	spoofed.ContainerID = "TGHU7654321"
+	req := trackRequest(t, []TrackPoint{validTrackPoint(), spoofed})
+	req.Header.Set("X-API-Key", key.ID+"."+key.Secret)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+	var result BatchResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if len(result.Rejected) != 1 || result.Rejected[0].Index != 1 {
+		t.Errorf("got rejected %+v, want index 1", result.Rejected)
+	}
+	if len(mock.written) != 1 || mock.written[0].ContainerID != "MSKU1234567" {
+		t.Errorf("got written %+v, want only MSKU1234567", mock.written)
+	}
+}
+
+func TestAdminHandler_IssueAndRevoke(t *testing.T) {
+	store := NewMemoryKeyStore()
+	admin := NewAdminHandler(store, "s3cret")
+	track := NewAuthenticator(store, NewMemoryDedupStore(100, NonceWindow)).Middleware(NewHandler(NewPipeline(&mockProducer{})))
+
+	do := func(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Wrong admin token
+	req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	if rec := do(admin, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// Issue
+	req = httptest.NewRequest(http.MethodPost, "/admin/keys",
+		bytes.NewBufferString(`{"device_id":"seal-1","container_ids":["MSKU1234567"]}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := do(admin, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("issue: got status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var issued issuedKey
+	json.NewDecoder(rec.Body).Decode(&issued)
+
+	req = trackRequest(t, []TrackPoint{validTrackPoint()})
+	req.Header.Set("X-API-Key", issued.APIKey)
+	if rec := do(track, req); rec.Code != http.StatusAccepted {
+		t.Fatalf("track with issued key: got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+
+	// List hides secrets
+	req = httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = do(admin, req)
+	var listed []DeviceKey
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("got listed %+v, want one key without secret", listed)
+	}
+
+	// Revoke
+	req = httptest.NewRequest(http.MethodDelete, "/admin/keys/"+issued.ID, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if rec := do(admin, req); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	req = trackRequest(t, []TrackPoint{validTrackPoint()})
+	req.Header.Set("X-API-Key", issued.APIKey)
+	if rec := do(track, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("track with revoked key: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxBatchBytes caps the decompressed request body, so a small compressed
+// upload can't expand into an unbounded amount of memory.
+const maxBatchBytes = 10 << 20 // 10MB
+
+// Supported request body formats, negotiated on Content-Type.
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeCBOR     = "application/cbor"
+)
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// decodeBatch reads the request body into TrackPoints according to its
+// Content-Encoding and Content-Type. A missing Content-Type means JSON.
+func decodeBatch(w http.ResponseWriter, r *http.Request) ([]TrackPoint, error) {
+	body, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	body = http.MaxBytesReader(w, body, maxBatchBytes)
+
+	mediaType := contentTypeJSON
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedMediaType, ct)
+		}
+	}
+
+	switch mediaType {
+	case contentTypeJSON:
+		var points []TrackPoint
+		if err := json.NewDecoder(body).Decode(&points); err != nil {
+			return nil, wrapBodyError(err, "invalid JSON: expected array")
+		}
+		return points, nil
+	case contentTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, wrapBodyError(err, "invalid protobuf")
+		}
+		points, err := unmarshalProtoBatch(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protobuf: %w", err)
+		}
+		return points, nil
+	case contentTypeCBOR:
+		var raw []cborTrackPoint
+		if err := cbor.NewDecoder(body).Decode(&raw); err != nil {
+			return nil, wrapBodyError(err, "invalid CBOR: expected array")
+		}
+		points := make([]TrackPoint, len(raw))
+		for i, p := range raw {
+			points[i] = p.trackPoint()
+		}
+		return points, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+	}
+}
+
+// decompress unwraps the body according to Content-Encoding.
+func decompress(r *http.Request) (io.ReadCloser, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return zr, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderMaxMemory(maxBatchBytes))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: content encoding %s", errUnsupportedMediaType, enc)
+	}
+}
+
+// wrapBodyError keeps *http.MaxBytesError visible to the caller so it can
+// answer 413 instead of 400.
+func wrapBodyError(err error, msg string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// cborTrackPoint is the compact CBOR form of a TrackPoint: integer map keys
+// (matching the protobuf field numbers) and a Unix millisecond timestamp.
+type cborTrackPoint struct {
+	ContainerID string  `cbor:"1,keyasint"`
+	Lat         float64 `cbor:"2,keyasint"`
+	Lon         float64 `cbor:"3,keyasint"`
+	TimestampMs int64   `cbor:"4,keyasint"`
+	Speed       float64 `cbor:"5,keyasint,omitempty"`
+	PointID     string  `cbor:"6,keyasint,omitempty"`
+
+	Heading     *float64 `cbor:"7,keyasint,omitempty"`
+	Altitude    *float64 `cbor:"8,keyasint,omitempty"`
+	Accuracy    *float64 `cbor:"9,keyasint,omitempty"`
+	Battery     *float64 `cbor:"10,keyasint,omitempty"`
+	Temperature *float64 `cbor:"11,keyasint,omitempty"`
+	Humidity    *float64 `cbor:"12,keyasint,omitempty"`
+	DoorOpen    *bool    `cbor:"13,keyasint,omitempty"`
+}
+
+func (p cborTrackPoint) trackPoint() TrackPoint {
+	return TrackPoint{
+		ContainerID: p.ContainerID,
+		Lat:         p.Lat,
+		Lon:         p.Lon,
+		Timestamp:   unixMilli(p.TimestampMs),
+		Speed:       p.Speed,
+		PointID:     p.PointID,
+		Heading:     p.Heading,
+		Altitude:    p.Altitude,
+		Accuracy:    p.Accuracy,
+		Battery:     p.Battery,
+		Temperature: p.Temperature,
+		Humidity:    p.Humidity,
+		DoorOpen:    p.DoorOpen,
+	}
+}
+
+// unixMilli converts a wire timestamp, keeping 0 as the zero time so that
+// Valid() reports a missing timestamp.
+func unixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// unmarshalProtoBatch decodes a TrackPointBatch (see proto/trackpoint.proto).
+// Unknown fields are skipped so newer devices can talk to older servers.
+func unmarshalProtoBatch(b []byte) ([]TrackPoint, error) {
+	var points []TrackPoint
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			tp, err := unmarshalProtoTrackPoint(msg)
+			if err != nil {
+				return nil, fmt.Errorf("point %d: %w", len(points), err)
+			}
+			points = append(points, tp)
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return points, nil
+}
+
+func unmarshalProtoTrackPoint(b []byte) (TrackPoint, error) {
+	var tp TrackPoint
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return tp, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			tp.ContainerID, n = consumeString(b)
+		case num == 2 && typ == protowire.Fixed64Type:
+			tp.Lat, n = consumeDouble(b)
+		case num == 3 && typ == protowire.Fixed64Type:
+			tp.Lon, n = consumeDouble(b)
+		case num == 4 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			tp.Timestamp = unixMilli(int64(v))
+		case num == 5 && typ == protowire.Fixed64Type:
+			tp.Speed, n = consumeDouble(b)
+		case num == 6 && typ == protowire.BytesType:
+			tp.PointID, n = consumeString(b)
+		case num >= 7 && num <= 12 && typ == protowire.Fixed64Type:
+			var v float64
+			v, n = consumeDouble(b)
+			*sensorField(&tp, num) = &v
+		case num == 13 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			open := v != 0
+			tp.DoorOpen = &open
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return tp, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return tp, nil
+}
+
+// sensorField maps the optional double fields 7-12 to their TrackPoint field.
+func sensorField(tp *TrackPoint, num protowire.Number) **float64 {
+	switch num {
+	case 7:
+		return &tp.Heading
+	case 8:
+		return &tp.Altitude
+	case 9:
+		return &tp.Accuracy
+	case 10:
+		return &tp.Battery
+	case 11:
+		return &tp.Temperature
+	default:
+		return &tp.Humidity
+	}
+}
+
+func consumeString(b []byte) (string, int) {
+	v, n := protowire.ConsumeBytes(b)
+	return string(v), n
+}
+
+func consumeDouble(b []byte) (float64, int) {
+	v, n := protowire.ConsumeFixed64(b)
+	return math.Float64frombits(v), n
+}
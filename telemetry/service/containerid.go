@@ -0,0 +1,35 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/lai/logistics/pkg/iso6346"
+)
+
+// ContainerIDMode is how strictly container IDs are checked against ISO 6346.
+// The consumer registers containers with the same iso6346.Mode.
+type ContainerIDMode iso6346.Mode
+
+const (
+	// ContainerIDLenient accepts any non-empty ID, e.g. for test fleets.
+	ContainerIDLenient = ContainerIDMode(iso6346.ModeLenient)
+	// ContainerIDFormat requires owner code, category and serial but
+	// ignores the check digit, for devices provisioned with made-up IDs.
+	ContainerIDFormat = ContainerIDMode(iso6346.ModeFormat)
+	// ContainerIDStrict also verifies the check digit.
+	ContainerIDStrict = ContainerIDMode(iso6346.ModeStrict)
+)
+
+// ParseContainerIDMode parses a config value.
+func ParseContainerIDMode(s string) (ContainerIDMode, error) {
+	mode, err := iso6346.ParseMode(s)
+	return ContainerIDMode(mode), err
+}
+
+// Check validates id according to the mode.
+func (m ContainerIDMode) Check(id string) error {
+	if err := iso6346.Mode(m).Check(id); err != nil {
+		return fmt.Errorf("invalid container_id: %w", err)
+	}
+	return nil
+}
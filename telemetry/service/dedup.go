@@ -0,0 +1,185 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClaimState is the state a dedup key was in when it was claimed.
+type ClaimState int
+
+const (
+	// ClaimNew means the key was free and is now claimed, pending until
+	// confirmed.
+	ClaimNew ClaimState = iota
+	// ClaimPending means a publish of the key is still in flight. It may yet
+	// fail, so the point is neither new nor a duplicate: retry later.
+	ClaimPending
+	// ClaimDone means the key was published within the dedup window.
+	ClaimDone
+)
+
+// PendingClaimTTL bounds how long a claim stays pending when its publisher
+// dies before confirming or releasing it.
+const PendingClaimTTL = time.Minute
+
+// DedupStore remembers which points have already been published so that
+// retried uploads don't produce duplicate history.
+type DedupStore interface {
+	// Claim claims key unless it is taken, and returns the state it was in.
+	// A new claim is pending for up to PendingClaimTTL.
+	Claim(ctx context.Context, key string) (ClaimState, error)
+	// Confirm marks a claimed key as published for the dedup window.
+	Confirm(ctx context.Context, key string) error
+	// Release forgets key, so a point whose publish failed can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// dedupKey identifies a point by its container and device-supplied ID.
+// Points without a PointID are never deduplicated.
+func dedupKey(tp TrackPoint) (string, bool) {
+	if tp.PointID == "" {
+		return "", false
+	}
+	return tp.ContainerID + "/" + tp.PointID, true
+}
+
+// MemoryDedupStore is an in-process LRU of recently claimed keys.
+// Each replica has its own cache, so use RedisDedupStore when running
+// more than one telemetry instance.
+type MemoryDedupStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+	pending bool
+}
+
+// NewMemoryDedupStore keeps up to size keys for at most window.
+func NewMemoryDedupStore(size int, window time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		window:  window,
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (s *MemoryDedupStore) Claim(_ context.Context, key string) (ClaimState, error) {
+	now := time.Now()
+	expires := now.Add(min(PendingClaimTTL, s.window))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		s.order.MoveToFront(el)
+		if now.Before(entry.expires) {
+			if entry.pending {
+				return ClaimPending, nil
+			}
+			return ClaimDone, nil
+		}
+		entry.expires, entry.pending = expires, true
+		return ClaimNew, nil
+	}
+
+	s.add(&dedupEntry{key: key, expires: expires, pending: true})
+	return ClaimNew, nil
+}
+
+func (s *MemoryDedupStore) Confirm(_ context.Context, key string) error {
+	expires := time.Now().Add(s.window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		entry.expires, entry.pending = expires, false
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.add(&dedupEntry{key: key, expires: expires})
+	return nil
+}
+
+func (s *MemoryDedupStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.order.Remove(el)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// add inserts entry, evicting the least recently used one past the size.
+// Called with s.mu held.
+func (s *MemoryDedupStore) add(entry *dedupEntry) {
+	s.entries[entry.key] = s.order.PushFront(entry)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).key)
+	}
+}
+
+// RedisDedupStore shares claimed keys between telemetry replicas.
+type RedisDedupStore struct {
+	client *redis.Client
+	window time.Duration
+}
+
+const (
+	redisDedupPrefix = "telemetry:dedup:"
+	// Values of a key: claimed and in flight, or published
+	redisClaimPending = "pending"
+	redisClaimDone    = "1"
+)
+
+// NewRedisDedupStore keeps keys in Redis with a TTL of window.
+func NewRedisDedupStore(client *redis.Client, window time.Duration) *RedisDedupStore {
+	return &RedisDedupStore{client: client, window: window}
+}
+
+func (s *RedisDedupStore) Claim(ctx context.Context, key string) (ClaimState, error) {
+	// SET NX is atomic, so two replicas racing on the same retry can't both win
+	ok, err := s.client.SetNX(ctx, redisDedupPrefix+key, redisClaimPending, min(PendingClaimTTL, s.window)).Result()
+	if err != nil || ok {
+		return ClaimNew, err
+	}
+	val, err := s.client.Get(ctx, redisDedupPrefix+key).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		// Released or expired in between; the retry will claim it
+		return ClaimPending, nil
+	case err != nil:
+		return ClaimNew, err
+	case val == redisClaimPending:
+		return ClaimPending, nil
+	default:
+		return ClaimDone, nil
+	}
+}
+
+func (s *RedisDedupStore) Confirm(ctx context.Context, key string) error {
+	return s.client.Set(ctx, redisDedupPrefix+key, redisClaimDone, s.window).Err()
+}
+
+func (s *RedisDedupStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisDedupPrefix+key).Err()
+}
@@ -3,8 +3,11 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func writeError(w http.ResponseWriter, code int, msg string) {
@@ -13,23 +16,60 @@ func writeError(w http.ResponseWriter, code int, msg string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
 type Producer interface {
 	Write(ctx context.Context, tp TrackPoint) error
+	// WriteBatch publishes points in one call. On error none of the points
+	// may be assumed published; retrying the whole batch is safe because
+	// consumers drop repeats by point_id.
+	WriteBatch(ctx context.Context, points []TrackPoint) error
 	Close() error
 }
 
-// Handler processes incoming GPS data.
+// Rejection describes a single point of a batch that failed validation.
+// Index refers to the position of the point in the uploaded array.
+type Rejection struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// BatchResult is the response body for POST /track.
+// Accepted counts every point the device may drop from its buffer,
+// including Duplicates that were already published by an earlier upload
+// and implausible points that went to the quarantine topic.
+type BatchResult struct {
+	Accepted    int         `json:"accepted"`
+	Duplicates  int         `json:"duplicates,omitempty"`
+	Quarantined int         `json:"quarantined,omitempty"`
+	Rejected    []Rejection `json:"rejected,omitempty"`
+}
+
+// Handler serves batch uploads over HTTP.
 type Handler struct {
-	producer Producer
+	pipeline *Pipeline
 }
 
-// NewHandler creates a handler with the given producer.
-func NewHandler(p Producer) *Handler {
-	return &Handler{producer: p}
+// NewHandler creates a handler feeding uploads into pipeline.
+func NewHandler(pipeline *Pipeline) *Handler {
+	return &Handler{pipeline: pipeline}
 }
 
 // ServeHTTP handles POST /track.
-// Always expects an array of TrackPoints.
+// Always expects an array of TrackPoints, as JSON, Protobuf or CBOR
+// depending on Content-Type (see codec.go).
+//
+// The whole batch is validated before anything is published, so a device can
+// drop exactly the readings listed in the response instead of re-uploading
+// its buffer:
+//   - 202: every point was accepted; with a DeliverySync producer this
+//     means every point was acknowledged by Kafka
+//   - 207: some points were accepted, the rest are listed in "rejected"
+//   - 400: no point was accepted
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
@@ -38,30 +78,73 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Use slice instead of single TrackPoint for batch processing:
 	// GPS devices buffer points locally and upload in batches (real-world behavior)
-	var points []TrackPoint
-	if err := json.NewDecoder(r.Body).Decode(&points); err != nil {
-		http.Error(w, "invalid JSON: expected array", http.StatusBadRequest)
+	points, err := decodeBatch(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, errUnsupportedMediaType):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.As(err, &tooLarge):
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
 
-	for _, tp := range points {
-		if err := tp.Valid(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if err := h.producer.Write(r.Context(), tp); err != nil {
-			slog.Error("kafka write failed",
-				"error", err,
-				"container_id", tp.ContainerID,
+	result, err := h.pipeline.Ingest(r.Context(), SourceHTTP, points)
+	var limited *RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		if limited.Limit == "device" {
+			device, _ := DeviceFromContext(r.Context())
+			slog.Warn("device rate limit exceeded",
+				"device_id", device.DeviceID,
 				"request_id", r.Header.Get("X-Request-ID"),
 			)
-			writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		}
+		requestsThrottled.WithLabelValues(limited.Limit).Inc()
+		tooManyRequests(w, limited.Wait, limited.Error())
+		return
+	case errors.Is(err, ErrPublishPending):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		slog.Error("kafka write failed",
+			"error", err,
+			"request_id", r.Header.Get("X-Request-ID"),
+		)
+		if errors.Is(err, ErrProducerSaturated) {
+			// Shed load right away instead of queueing behind a full buffer
+			requestsThrottled.WithLabelValues("producer").Inc()
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "producer buffer full, retry later")
 			return
 		}
+		writeError(w, http.StatusServiceUnavailable, "service temporarily unavailable")
+		return
 	}
+
 	slog.Info("wrote track points",
-		"count", len(points),
+		"count", result.Accepted,
+		"duplicates", result.Duplicates,
+		"quarantined", result.Quarantined,
+		"rejected", len(result.Rejected),
 		"request_id", r.Header.Get("X-Request-ID"),
 	)
-	w.WriteHeader(http.StatusAccepted)
+
+	switch {
+	case len(result.Rejected) == 0:
+		writeJSON(w, http.StatusAccepted, result)
+	case result.Accepted > 0:
+		writeJSON(w, http.StatusMultiStatus, result)
+	default:
+		writeJSON(w, http.StatusBadRequest, result)
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	writeError(w, http.StatusTooManyRequests, msg)
 }
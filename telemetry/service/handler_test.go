@@ -2,13 +2,24 @@ package service
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // mockProducer implements Producer for testing.
@@ -25,10 +36,22 @@ func (m *mockProducer) Write(ctx context.Context, tp TrackPoint) error {
 	return nil
 }
 
+func (m *mockProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, points...)
+	return nil
+}
+
 func (m *mockProducer) Close() error {
 	return nil
 }
 
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func validTrackPoint() TrackPoint {
 	return TrackPoint{
 		ContainerID: "MSKU1234567",
@@ -143,6 +166,34 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			},
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:   "heading out of range",
+			method: http.MethodPost,
+			body: []TrackPoint{
+				{ContainerID: "TEST123", Lat: 25.0, Lon: 121.0, Timestamp: time.Now(), Heading: ptr(360.0)},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "battery out of range",
+			method: http.MethodPost,
+			body: []TrackPoint{
+				{ContainerID: "TEST123", Lat: 25.0, Lon: 121.0, Timestamp: time.Now(), Battery: ptr(101.0)},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "reefer sensor payload",
+			method: http.MethodPost,
+			body: []TrackPoint{
+				{
+					ContainerID: "TEST123", Lat: 25.0, Lon: 121.0, Timestamp: time.Now(),
+					Heading: ptr(271.5), Altitude: ptr(12.0), Accuracy: ptr(4.0), Battery: ptr(87.0),
+					Temperature: ptr(-18.5), Humidity: ptr(65.0), DoorOpen: ptr(false),
+				},
+			},
+			wantStatus: http.StatusAccepted,
+		},
 		{
 			name:       "producer error",
 			method:     http.MethodPost,
@@ -155,7 +206,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mock := &mockProducer{err: tt.prodErr}
-			h := NewHandler(mock)
+			h := NewHandler(NewPipeline(mock))
 
 			var body []byte
 			switch v := tt.body.(type) {
@@ -186,7 +237,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 
 func TestHandler_WritesAllPoints(t *testing.T) {
 	mock := &mockProducer{}
-	h := NewHandler(mock)
+	h := NewHandler(NewPipeline(mock))
 
 	points := []TrackPoint{
 		{ContainerID: "A", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: 1},
@@ -215,15 +266,64 @@ func TestHandler_WritesAllPoints(t *testing.T) {
 	}
 }
 
-func TestHandler_StopsOnFirstInvalid(t *testing.T) {
+func TestHandler_PartialSuccess(t *testing.T) {
 	mock := &mockProducer{}
-	h := NewHandler(mock)
+	h := NewHandler(NewPipeline(mock))
 
-	// Second point is invalid
+	// Second and fourth points are invalid
 	points := []TrackPoint{
 		{ContainerID: "VALID", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: 1},
 		{ContainerID: "", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: 1}, // invalid
 		{ContainerID: "ALSO_VALID", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: 1},
+		{ContainerID: "BAD_LAT", Lat: 100, Lon: 20, Timestamp: time.Now(), Speed: 1}, // invalid
+	}
+
+	body, _ := json.Marshal(points)
+	req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+
+	// All valid points are published, including those after an invalid one
+	if len(mock.written) != 2 {
+		t.Fatalf("got %d written points, want 2", len(mock.written))
+	}
+	if mock.written[1].ContainerID != "ALSO_VALID" {
+		t.Errorf("got ContainerID %q, want %q", mock.written[1].ContainerID, "ALSO_VALID")
+	}
+
+	var result BatchResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Accepted != 2 {
+		t.Errorf("got accepted %d, want 2", result.Accepted)
+	}
+	want := []Rejection{
+		{Index: 1, Error: "container_id required"},
+		{Index: 3, Error: "lat out of range"},
+	}
+	if len(result.Rejected) != len(want) {
+		t.Fatalf("got %d rejections, want %d", len(result.Rejected), len(want))
+	}
+	for i, got := range result.Rejected {
+		if got != want[i] {
+			t.Errorf("rejection %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestHandler_AllInvalid(t *testing.T) {
+	mock := &mockProducer{}
+	h := NewHandler(NewPipeline(mock))
+
+	points := []TrackPoint{
+		{ContainerID: "", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: 1},
+		{ContainerID: "A", Lat: 10, Lon: 20, Timestamp: time.Now(), Speed: -5},
 	}
 
 	body, _ := json.Marshal(points)
@@ -235,9 +335,512 @@ func TestHandler_StopsOnFirstInvalid(t *testing.T) {
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
 	}
+	if len(mock.written) != 0 {
+		t.Errorf("got %d written points, want 0", len(mock.written))
+	}
+
+	var result BatchResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Rejected) != 2 {
+		t.Errorf("got %d rejections, want 2", len(result.Rejected))
+	}
+}
+
+func TestHandler_DropsRetriedPoints(t *testing.T) {
+	mock := &mockProducer{}
+	h := NewHandler(NewPipeline(mock, WithDedup(NewMemoryDedupStore(100, time.Minute))))
+
+	ts := time.Now()
+	points := []TrackPoint{
+		{ContainerID: "A", Lat: 10, Lon: 20, Timestamp: ts, Speed: 1, PointID: "1"},
+		{ContainerID: "A", Lat: 11, Lon: 21, Timestamp: ts, Speed: 1, PointID: "2"},
+		{ContainerID: "B", Lat: 12, Lon: 22, Timestamp: ts, Speed: 1, PointID: "1"}, // same ID, other container
+		{ContainerID: "A", Lat: 12, Lon: 22, Timestamp: ts, Speed: 1},               // no ID, never deduplicated
+	}
+	body, _ := json.Marshal(points)
+
+	// The device retries the same upload after a lost response
+	for attempt := 0; attempt < 2; attempt++ {
+		req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("attempt %d: got status %d, want %d", attempt, rec.Code, http.StatusAccepted)
+		}
+	}
 
-	// Only the first point should be written before validation fails
+	if len(mock.written) != 5 {
+		t.Errorf("got %d written points, want 5 (3 keyed once + unkeyed twice)", len(mock.written))
+	}
+}
+
+func TestHandler_ReleasesClaimOnWriteFailure(t *testing.T) {
+	mock := &mockProducer{err: errors.New("kafka unavailable")}
+	h := NewHandler(NewPipeline(mock, WithDedup(NewMemoryDedupStore(100, time.Minute))))
+
+	tp := validTrackPoint()
+	tp.PointID = "42"
+	body, _ := json.Marshal([]TrackPoint{tp})
+
+	req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// Kafka is back: the retry must be published, not treated as a duplicate
+	mock.err = nil
+	req = httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
 	if len(mock.written) != 1 {
-		t.Errorf("got %d written points, want 1 (should stop on first invalid)", len(mock.written))
+		t.Errorf("got %d written points, want 1", len(mock.written))
+	}
+}
+
+// ctxDedupStore fails like Redis does when called with a done context.
+type ctxDedupStore struct {
+	*MemoryDedupStore
+}
+
+func (s ctxDedupStore) Claim(ctx context.Context, key string) (ClaimState, error) {
+	if err := ctx.Err(); err != nil {
+		return ClaimNew, err
+	}
+	return s.MemoryDedupStore.Claim(ctx, key)
+}
+
+func (s ctxDedupStore) Confirm(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryDedupStore.Confirm(ctx, key)
+}
+
+func (s ctxDedupStore) Release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryDedupStore.Release(ctx, key)
+}
+
+// cancellingProducer fails its first write by cancelling the caller's
+// context, like a timeout expiring mid-write.
+type cancellingProducer struct {
+	mockProducer
+	cancel context.CancelFunc
+}
+
+func (p *cancellingProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+		return ctx.Err()
+	}
+	return p.mockProducer.WriteBatch(ctx, points)
+}
+
+func TestPipeline_ReleasesClaimAfterContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	prod := &cancellingProducer{cancel: cancel}
+	p := NewPipeline(prod, WithDedup(ctxDedupStore{NewMemoryDedupStore(100, time.Minute)}))
+
+	tp := validTrackPoint()
+	tp.PointID = "42"
+	if _, err := p.Ingest(ctx, SourceMQTT, []TrackPoint{tp}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The retry comes with a new context and must not be a duplicate
+	result, err := p.Ingest(context.Background(), SourceMQTT, []TrackPoint{tp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Duplicates != 0 || len(prod.written) != 1 {
+		t.Errorf("got %d duplicates, %d written, want the retry published", result.Duplicates, len(prod.written))
+	}
+}
+
+// gatedProducer holds every write until it is given its result.
+type gatedProducer struct {
+	mockProducer
+	started chan struct{}
+	result  chan error
+}
+
+func (p *gatedProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	p.started <- struct{}{}
+	if err := <-p.result; err != nil {
+		return err
+	}
+	return p.mockProducer.WriteBatch(ctx, points)
+}
+
+func TestHandler_RetryDuringPendingWrite(t *testing.T) {
+	prod := &gatedProducer{started: make(chan struct{}, 2), result: make(chan error, 1)}
+	p := NewPipeline(prod, WithDedup(NewMemoryDedupStore(100, time.Minute)))
+	h := NewHandler(p)
+
+	tp := validTrackPoint()
+	tp.PointID = "42"
+	body, _ := json.Marshal([]TrackPoint{tp})
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(body)))
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- post() }()
+	<-prod.started
+
+	// The device gave up on the first response and retries while it is
+	// still being written: that is not a duplicate yet
+	rec := post()
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("got status %d, want 503 with Retry-After", rec.Code)
+	}
+
+	prod.result <- errors.New("kafka unavailable")
+	if rec := <-first; rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first attempt: got status %d, want 503", rec.Code)
+	}
+
+	prod.result <- nil
+	if rec := post(); rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", rec.Code)
+	}
+	if len(prod.written) != 1 {
+		t.Errorf("got %d written points, want 1", len(prod.written))
+	}
+}
+
+func TestMemoryDedupStore_ClaimStates(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupStore(100, 20*time.Millisecond)
+
+	steps := []struct {
+		do   func() (ClaimState, error)
+		want ClaimState
+	}{
+		{func() (ClaimState, error) { return s.Claim(ctx, "k") }, ClaimNew},
+		{func() (ClaimState, error) { return s.Claim(ctx, "k") }, ClaimPending},
+		// An unconfirmed claim expires, as when its publisher died
+		{func() (ClaimState, error) { time.Sleep(30 * time.Millisecond); return s.Claim(ctx, "k") }, ClaimNew},
+		{func() (ClaimState, error) { return ClaimDone, s.Confirm(ctx, "k") }, ClaimDone},
+		{func() (ClaimState, error) { return s.Claim(ctx, "k") }, ClaimDone},
+		{func() (ClaimState, error) { return ClaimNew, s.Release(ctx, "k") }, ClaimNew},
+		{func() (ClaimState, error) { return s.Claim(ctx, "k") }, ClaimNew},
+	}
+	for i, step := range steps {
+		got, err := step.do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Fatalf("step %d: got %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+// marshalProtoBatch encodes points as a TrackPointBatch, the way a device would.
+func marshalProtoBatch(points []TrackPoint) []byte {
+	var batch []byte
+	for _, tp := range points {
+		var msg []byte
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, tp.ContainerID)
+		msg = protowire.AppendTag(msg, 2, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(tp.Lat))
+		msg = protowire.AppendTag(msg, 3, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(tp.Lon))
+		msg = protowire.AppendTag(msg, 4, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(tp.Timestamp.UnixMilli()))
+		msg = protowire.AppendTag(msg, 5, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(tp.Speed))
+		if tp.Temperature != nil {
+			msg = protowire.AppendTag(msg, 11, protowire.Fixed64Type)
+			msg = protowire.AppendFixed64(msg, math.Float64bits(*tp.Temperature))
+		}
+		if tp.DoorOpen != nil {
+			msg = protowire.AppendTag(msg, 13, protowire.VarintType)
+			msg = protowire.AppendVarint(msg, protowire.EncodeBool(*tp.DoorOpen))
+		}
+		// Unknown field from a newer firmware must be skipped
+		msg = protowire.AppendTag(msg, 99, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, 7)
+
+		batch = protowire.AppendTag(batch, 1, protowire.BytesType)
+		batch = protowire.AppendBytes(batch, msg)
+	}
+	return batch
+}
+
+func marshalCBORBatch(t *testing.T, points []TrackPoint) []byte {
+	t.Helper()
+	raw := make([]cborTrackPoint, len(points))
+	for i, tp := range points {
+		raw[i] = cborTrackPoint{
+			ContainerID: tp.ContainerID,
+			Lat:         tp.Lat,
+			Lon:         tp.Lon,
+			TimestampMs: tp.Timestamp.UnixMilli(),
+			Speed:       tp.Speed,
+			Temperature: tp.Temperature,
+			DoorOpen:    tp.DoorOpen,
+		}
+	}
+	data, err := cbor.Marshal(raw)
+	if err != nil {
+		t.Fatalf("failed to marshal CBOR: %v", err)
+	}
+	return data
+}
+
+func TestHandler_BinaryFormats(t *testing.T) {
+	points := []TrackPoint{
+		{ContainerID: "A", Lat: 10.5, Lon: 20.25, Timestamp: time.UnixMilli(1769162400123).UTC(), Speed: 1.5},
+		{ContainerID: "B", Lat: -33.9, Lon: 151.2, Timestamp: time.UnixMilli(1769162401000).UTC(), Speed: 0,
+			Temperature: ptr(-18.5), DoorOpen: ptr(true)},
+	}
+	jsonBody, _ := json.Marshal(points)
+
+	compress := map[string]func(t *testing.T, b []byte) []byte{
+		"": func(t *testing.T, b []byte) []byte { return b },
+		"gzip": func(t *testing.T, b []byte) []byte {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(b)
+			zw.Close()
+			return buf.Bytes()
+		},
+		"zstd": func(t *testing.T, b []byte) []byte {
+			zw, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatalf("zstd writer: %v", err)
+			}
+			defer zw.Close()
+			return zw.EncodeAll(b, nil)
+		},
+	}
+
+	formats := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/json", jsonBody},
+		{"application/x-protobuf", marshalProtoBatch(points)},
+		{"application/cbor", marshalCBORBatch(t, points)},
+	}
+
+	for _, f := range formats {
+		for encoding, enc := range compress {
+			t.Run(f.contentType+"/"+encoding, func(t *testing.T) {
+				mock := &mockProducer{}
+				h := NewHandler(NewPipeline(mock))
+
+				req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(enc(t, f.body)))
+				req.Header.Set("Content-Type", f.contentType)
+				if encoding != "" {
+					req.Header.Set("Content-Encoding", encoding)
+				}
+				rec := httptest.NewRecorder()
+
+				h.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusAccepted {
+					t.Fatalf("got status %d, want %d; body=%s", rec.Code, http.StatusAccepted, rec.Body)
+				}
+				if len(mock.written) != len(points) {
+					t.Fatalf("got %d written points, want %d", len(mock.written), len(points))
+				}
+				for i, got := range mock.written {
+					want := points[i]
+					if got.ContainerID != want.ContainerID || got.Lat != want.Lat || got.Lon != want.Lon ||
+						got.Speed != want.Speed || !got.Timestamp.Equal(want.Timestamp) ||
+						!reflect.DeepEqual(got.Temperature, want.Temperature) || !reflect.DeepEqual(got.DoorOpen, want.DoorOpen) {
+						t.Errorf("point %d: got %+v, want %+v", i, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestHandler_BinaryFormatsRejectNonFinite(t *testing.T) {
+	ts := time.UnixMilli(1769162400123).UTC()
+	// JSON can't carry NaN or Inf, the binary formats can
+	points := []TrackPoint{
+		{ContainerID: "A", Lat: 10, Lon: 20, Timestamp: ts, Speed: 1},
+		{ContainerID: "B", Lat: math.NaN(), Lon: 20, Timestamp: ts, Speed: 1},
+		{ContainerID: "C", Lat: 10, Lon: math.Inf(-1), Timestamp: ts, Speed: 1},
+		{ContainerID: "D", Lat: 10, Lon: 20, Timestamp: ts, Speed: math.Inf(1)},
+		{ContainerID: "E", Lat: 10, Lon: 20, Timestamp: ts, Speed: 1, Temperature: ptr(math.NaN())},
+	}
+	want := []Rejection{
+		{Index: 1, Error: "lat must be a finite number"},
+		{Index: 2, Error: "lon must be a finite number"},
+		{Index: 3, Error: "speed must be a finite number"},
+		{Index: 4, Error: "temperature must be a finite number"},
+	}
+
+	formats := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/x-protobuf", marshalProtoBatch(points)},
+		{"application/cbor", marshalCBORBatch(t, points)},
+	}
+
+	for _, f := range formats {
+		t.Run(f.contentType, func(t *testing.T) {
+			mock := &mockProducer{}
+			h := NewHandler(NewPipeline(mock))
+
+			req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader(f.body))
+			req.Header.Set("Content-Type", f.contentType)
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusMultiStatus {
+				t.Fatalf("got status %d, want %d; body=%s", rec.Code, http.StatusMultiStatus, rec.Body)
+			}
+			if len(mock.written) != 1 || mock.written[0].ContainerID != "A" {
+				t.Fatalf("got written %+v, want only point A", mock.written)
+			}
+			var result BatchResult
+			if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if !reflect.DeepEqual(result.Rejected, want) {
+				t.Errorf("got rejections %+v, want %+v", result.Rejected, want)
+			}
+		})
+	}
+}
+
+func TestHandler_UnsupportedFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+	}{
+		{name: "content type", contentType: "text/csv"},
+		{name: "content encoding", contentType: "application/json", encoding: "br"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(NewPipeline(&mockProducer{}))
+
+			req := httptest.NewRequest(http.MethodPost, "/track", bytes.NewReader([]byte("[]")))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusUnsupportedMediaType)
+			}
+		})
+	}
+}
+
+// discardProducer counts points without keeping them, so benchmarks measure
+// the handler rather than slice growth.
+type discardProducer struct {
+	points int
+}
+
+func (d *discardProducer) Write(ctx context.Context, tp TrackPoint) error {
+	d.points++
+	return nil
+}
+
+func (d *discardProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	d.points += len(points)
+	return nil
+}
+
+func (d *discardProducer) Close() error {
+	return nil
+}
+
+// BenchmarkHandler_ServeHTTP reports points/s through decode, validation and
+// a single WriteBatch per request. The PRD target is 10k points/s per
+// replica including Kafka, so the handler alone should be far above that.
+func BenchmarkHandler_ServeHTTP(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, size := range []int{1, 100, 500} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			points := make([]TrackPoint, size)
+			for i := range points {
+				points[i] = validTrackPoint()
+				points[i].PointID = strconv.Itoa(i)
+			}
+			body, _ := json.Marshal(points)
+			producer := &discardProducer{}
+			h := NewHandler(NewPipeline(producer))
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				req := httptest.NewRequest(http.MethodPost, "/api/track", bytes.NewReader(body))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != http.StatusAccepted {
+					b.Fatalf("got status %d", rec.Code)
+				}
+			}
+			b.ReportMetric(float64(producer.points)/b.Elapsed().Seconds(), "points/s")
+		})
+	}
+}
+
+func TestHandler_ContainerIDMode(t *testing.T) {
+	tests := []struct {
+		mode        ContainerIDMode
+		containerID string
+		wantStatus  int
+		wantID      string // as published
+	}{
+		{ContainerIDLenient, "C001", http.StatusAccepted, "C001"},
+		{ContainerIDLenient, "c001", http.StatusAccepted, "C001"},
+		{ContainerIDLenient, " ", http.StatusBadRequest, ""},
+		{ContainerIDFormat, "C001", http.StatusBadRequest, ""},
+		{ContainerIDFormat, "MSKU1234567", http.StatusAccepted, "MSKU1234567"},
+		{ContainerIDFormat, "msku-123456-7", http.StatusAccepted, "MSKU1234567"},
+		{ContainerIDStrict, "MSKU1234567", http.StatusBadRequest, ""}, // check digit is 5
+		{ContainerIDStrict, "MSKU1234565", http.StatusAccepted, "MSKU1234565"},
+		{ContainerIDStrict, "msku 123456 5", http.StatusAccepted, "MSKU1234565"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+"/"+tt.containerID, func(t *testing.T) {
+			tp := validTrackPoint()
+			tp.ContainerID = tt.containerID
+			mock := &mockProducer{}
+			rec := postPoints(NewHandler(NewPipeline(mock, WithContainerIDMode(tt.mode))), []TrackPoint{tp})
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantID != "" && (len(mock.written) != 1 || mock.written[0].ContainerID != tt.wantID) {
+				t.Errorf("got written %+v, want one point for %s", mock.written, tt.wantID)
+			}
+		})
 	}
 }
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lai/logistics/pkg/iso6346"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrKeyNotFound is returned for unknown or revoked key IDs.
+var ErrKeyNotFound = errors.New("device key not found")
+
+// DeviceKey is a credential issued to one device. The secret is used as the
+// API key itself or as the HMAC key for signed requests.
+type DeviceKey struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Secret   string `json:"secret,omitempty"`
+	// ContainerIDs the device may report for; "*" allows any container
+	// (e.g. a yard gateway relaying for many seals).
+	ContainerIDs []string  `json:"container_ids"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Allows reports whether the key may publish points for containerID, in
+// either its printed or its normalized form.
+func (k DeviceKey) Allows(containerID string) bool {
+	containerID = iso6346.Normalize(containerID)
+	return slices.ContainsFunc(k.ContainerIDs, func(id string) bool {
+		return id == "*" || iso6346.Normalize(id) == containerID
+	})
+}
+
+// KeyStore persists device keys.
+type KeyStore interface {
+	Get(ctx context.Context, id string) (DeviceKey, error)
+	Put(ctx context.Context, key DeviceKey) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]DeviceKey, error)
+}
+
+// NewDeviceKey generates a key with a random ID and secret.
+func NewDeviceKey(deviceID string, containerIDs []string) (DeviceKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	return DeviceKey{
+		ID:           id,
+		DeviceID:     deviceID,
+		Secret:       secret,
+		ContainerIDs: containerIDs,
+		CreatedAt:    time.Now().UTC(),
+	}, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryKeyStore keeps keys in process memory. Keys are lost on restart and
+// not shared between replicas, so it is only meant for development and tests.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]DeviceKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]DeviceKey)}
+}
+
+func (s *MemoryKeyStore) Get(_ context.Context, id string) (DeviceKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return DeviceKey{}, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryKeyStore) Put(_ context.Context, key DeviceKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryKeyStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *MemoryKeyStore) List(_ context.Context) ([]DeviceKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]DeviceKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// RedisKeyStore shares device keys between telemetry replicas. Each key is a
+// JSON string; a set indexes the IDs for listing.
+type RedisKeyStore struct {
+	client *redis.Client
+}
+
+const (
+	redisKeyPrefix = "telemetry:device-key:"
+	redisKeyIndex  = "telemetry:device-keys"
+)
+
+func NewRedisKeyStore(client *redis.Client) *RedisKeyStore {
+	return &RedisKeyStore{client: client}
+}
+
+func (s *RedisKeyStore) Get(ctx context.Context, id string) (DeviceKey, error) {
+	data, err := s.client.Get(ctx, redisKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return DeviceKey{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return DeviceKey{}, err
+	}
+	var key DeviceKey
+	err = json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (s *RedisKeyStore) Put(ctx context.Context, key DeviceKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisKeyPrefix+key.ID, data, 0)
+		pipe.SAdd(ctx, redisKeyIndex, key.ID)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisKeyStore) Delete(ctx context.Context, id string) error {
+	var del *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, redisKeyPrefix+id)
+		pipe.SRem(ctx, redisKeyIndex, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *RedisKeyStore) List(ctx context.Context) ([]DeviceKey, error) {
+	ids, err := s.client.SMembers(ctx, redisKeyIndex).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]DeviceKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.Get(ctx, id)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
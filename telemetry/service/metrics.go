@@ -0,0 +1,62 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Ingest sources for PointsReceived and PointsRejected.
+const (
+	SourceHTTP    = "http"
+	SourceMQTT    = "mqtt"
+	SourceTracker = "tracker"
+)
+
+var (
+	// PointsReceived counts points that passed validation, including
+	// duplicates and quarantined ones.
+	PointsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telemetry_points_received_total",
+		Help: "Track points accepted for publishing, by ingest source.",
+	}, []string{"source"})
+
+	// PointsRejected counts points that failed validation.
+	PointsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telemetry_points_rejected_total",
+		Help: "Track points rejected by validation, by ingest source and reason.",
+	}, []string{"source", "reason"})
+
+	pointsQuarantined = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telemetry_points_quarantined_total",
+		Help: "Implausible track points sent to the quarantine topic.",
+	})
+
+	pointsDuplicate = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telemetry_points_duplicate_total",
+		Help: "Track points dropped because their point_id was already published.",
+	})
+
+	requestsThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telemetry_requests_throttled_total",
+		Help: "Requests answered 429 or 503 to shed load, by limit.",
+	}, []string{"limit"})
+
+	produceDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "telemetry_kafka_produce_duration_seconds",
+		Help:    "Time from handing a batch to the Kafka writer until it was acknowledged or given up on.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms to ~8s
+	}, []string{"topic"})
+
+	produceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telemetry_kafka_produce_errors_total",
+		Help: "Messages the Kafka writer failed to deliver.",
+	}, []string{"topic"})
+)
+
+// RejectReason turns a TrackPoint.Valid error into a reason label. Those
+// errors are fixed strings, so the label set stays small.
+func RejectReason(err error) string {
+	return strings.ReplaceAll(err.Error(), " ", "_")
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandler_CountsPointsByOutcome(t *testing.T) {
+	h := NewHandler(NewPipeline(&mockProducer{}, WithContainerIDMode(ContainerIDFormat)))
+
+	badLat := validTrackPoint()
+	badLat.Lat = 91
+	badID := validTrackPoint()
+	badID.ContainerID = "MSKX1234567"
+
+	received := testutil.ToFloat64(PointsReceived.WithLabelValues(SourceHTTP))
+	latRejects := testutil.ToFloat64(PointsRejected.WithLabelValues(SourceHTTP, "lat_out_of_range"))
+	idRejects := testutil.ToFloat64(PointsRejected.WithLabelValues(SourceHTTP, "invalid_container_id"))
+
+	rec := postPoints(h, []TrackPoint{validTrackPoint(), badLat, badID})
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+
+	if got := testutil.ToFloat64(PointsReceived.WithLabelValues(SourceHTTP)) - received; got != 1 {
+		t.Errorf("got %v received, want 1", got)
+	}
+	if got := testutil.ToFloat64(PointsRejected.WithLabelValues(SourceHTTP, "lat_out_of_range")) - latRejects; got != 1 {
+		t.Errorf("got %v lat_out_of_range rejects, want 1", got)
+	}
+	if got := testutil.ToFloat64(PointsRejected.WithLabelValues(SourceHTTP, "invalid_container_id")) - idRejects; got != 1 {
+		t.Errorf("got %v invalid_container_id rejects, want 1", got)
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lai/logistics/pkg/iso6346"
+)
+
+var errContainerNotAuthorized = errors.New("container not authorized for this device")
+
+// ErrPublishPending is returned by Pipeline.Ingest when a point is still
+// being published by an earlier attempt, which may yet fail. Nothing was
+// published; retry shortly.
+var ErrPublishPending = errors.New("points are still being published, retry later")
+
+// dedupCleanupTimeout bounds confirming and releasing dedup claims. They
+// run even when the request's context is done, as a claim left pending
+// blocks the device's retries.
+const dedupCleanupTimeout = 2 * time.Second
+
+// RateLimitError is returned by Pipeline.Ingest when a device, or the whole
+// fleet, is over its rate. Nothing was published; retry after Wait.
+type RateLimitError struct {
+	Limit string // "device" or "global"
+	Wait  time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Limit + " rate limit exceeded"
+}
+
+// Pipeline runs the ingest stages every source goes through, so points
+// arriving over HTTP, MQTT or a tracker socket are held to the same rules:
+// rate limits, validation, the container ID check, device authorization,
+// plausibility, dedup and finally the Producer.
+type Pipeline struct {
+	producer     Producer
+	dedup        DedupStore
+	limiter      *RateLimiter
+	plausibility *Plausibility
+	quarantine   Producer
+	containerIDs ContainerIDMode
+}
+
+// PipelineOption configures optional Pipeline stages.
+type PipelineOption func(*Pipeline)
+
+// WithDedup drops points whose PointID was already published.
+func WithDedup(store DedupStore) PipelineOption {
+	return func(p *Pipeline) {
+		p.dedup = store
+	}
+}
+
+// WithRateLimit turns away devices, or the whole fleet, going over the
+// configured rates.
+func WithRateLimit(l *RateLimiter) PipelineOption {
+	return func(p *Pipeline) {
+		p.limiter = l
+	}
+}
+
+// WithContainerIDMode rejects points whose container ID fails the ISO 6346
+// check of mode.
+func WithContainerIDMode(mode ContainerIDMode) PipelineOption {
+	return func(p *Pipeline) {
+		p.containerIDs = mode
+	}
+}
+
+// WithPlausibility screens points with pl. Implausible points are published
+// to quarantine, or rejected when quarantine is nil.
+func WithPlausibility(pl *Plausibility, quarantine Producer) PipelineOption {
+	return func(p *Pipeline) {
+		p.plausibility = pl
+		p.quarantine = quarantine
+	}
+}
+
+// NewPipeline creates a pipeline publishing to producer.
+func NewPipeline(producer Producer, opts ...PipelineOption) *Pipeline {
+	p := &Pipeline{producer: producer}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Ingest validates points and publishes the ones that pass, counting them
+// under source. Points failing a check are listed in the result's Rejected,
+// by their index in points. Container IDs are normalized first, so the
+// printed form "csqu 305438 3" is published as CSQU3054383.
+//
+// A *RateLimitError means nothing was looked at, and ErrPublishPending that
+// nothing was published. Any other error is a failed write: none of the
+// points may be assumed published, and their dedup claims were released so
+// a retry goes through.
+//
+// A DeviceKey in ctx (see Authenticator) limits the containers the points
+// may be for and is charged for the request; without one every container
+// in points is charged instead.
+func (p *Pipeline) Ingest(ctx context.Context, source string, points []TrackPoint) (BatchResult, error) {
+	var result BatchResult
+	device, authenticated := DeviceFromContext(ctx)
+
+	if p.limiter != nil {
+		if ok, wait := p.limiter.AllowDevices(rateLimitKeys(device, authenticated, points)); !ok {
+			return result, &RateLimitError{Limit: "device", Wait: wait}
+		}
+	}
+
+	valid := make([]TrackPoint, 0, len(points))
+	indexes := make([]int, 0, len(points))
+	for i, tp := range points {
+		tp.QuarantineReason = "" // only set by us
+		tp.ContainerID = iso6346.Normalize(tp.ContainerID)
+		if err := tp.Valid(); err != nil {
+			PointsRejected.WithLabelValues(source, RejectReason(err)).Inc()
+			result.Rejected = append(result.Rejected, Rejection{Index: i, Error: err.Error()})
+			continue
+		}
+		if err := p.containerIDs.Check(tp.ContainerID); err != nil {
+			PointsRejected.WithLabelValues(source, "invalid_container_id").Inc()
+			result.Rejected = append(result.Rejected, Rejection{Index: i, Error: err.Error()})
+			continue
+		}
+		if authenticated && !device.Allows(tp.ContainerID) {
+			slog.Warn("container not authorized for device",
+				"key_id", device.ID,
+				"device_id", device.DeviceID,
+				"container_id", tp.ContainerID,
+			)
+			PointsRejected.WithLabelValues(source, "container_not_authorized").Inc()
+			result.Rejected = append(result.Rejected, Rejection{Index: i, Error: errContainerNotAuthorized.Error()})
+			continue
+		}
+		valid = append(valid, tp)
+		indexes = append(indexes, i)
+	}
+
+	if p.limiter != nil {
+		if ok, wait := p.limiter.AllowPoints(len(valid)); !ok {
+			return result, &RateLimitError{Limit: "global", Wait: wait}
+		}
+	}
+
+	valid, flagged := p.screen(source, valid, indexes, &result)
+	fresh, claimed, err := p.deduplicate(ctx, valid)
+	if err != nil {
+		return result, err
+	}
+	if len(flagged) > 0 {
+		if err := p.quarantine.WriteBatch(ctx, flagged); err != nil {
+			p.release(ctx, claimed)
+			return result, fmt.Errorf("quarantine: %w", err)
+		}
+		result.Quarantined = len(flagged)
+	}
+
+	result.Accepted = len(valid) + len(flagged)
+	result.Duplicates = len(valid) - len(fresh)
+
+	if len(fresh) > 0 {
+		if err := p.producer.WriteBatch(ctx, fresh); err != nil {
+			// Unpublished points must stay retryable
+			p.release(ctx, claimed)
+			return result, err
+		}
+	}
+	p.confirm(ctx, claimed)
+
+	PointsReceived.WithLabelValues(source).Add(float64(result.Accepted))
+	pointsDuplicate.Add(float64(result.Duplicates))
+	pointsQuarantined.Add(float64(result.Quarantined))
+	return result, nil
+}
+
+// screen splits valid points into plausible and flagged ones. Without a
+// quarantine producer flagged points are added to result.Rejected instead.
+// indexes holds the position of each valid point in the uploaded array.
+func (p *Pipeline) screen(source string, valid []TrackPoint, indexes []int, result *BatchResult) ([]TrackPoint, []TrackPoint) {
+	if p.plausibility == nil {
+		return valid, nil
+	}
+
+	plausible := make([]TrackPoint, 0, len(valid))
+	var flagged []TrackPoint
+	for i, tp := range valid {
+		reason := p.plausibility.Check(tp)
+		if reason == "" {
+			plausible = append(plausible, tp)
+			continue
+		}
+		slog.Warn("implausible track point",
+			"reason", reason,
+			"source", source,
+			"container_id", tp.ContainerID,
+			"lat", tp.Lat,
+			"lon", tp.Lon,
+			"timestamp", tp.Timestamp,
+		)
+		if p.quarantine == nil {
+			PointsRejected.WithLabelValues(source, "implausible").Inc()
+			result.Rejected = append(result.Rejected, Rejection{Index: indexes[i], Error: reason})
+			continue
+		}
+		tp.QuarantineReason = reason
+		flagged = append(flagged, tp)
+	}
+	slices.SortFunc(result.Rejected, func(a, b Rejection) int { return cmp.Compare(a.Index, b.Index) })
+	return plausible, flagged
+}
+
+// deduplicate returns the points that have not been published yet, along
+// with the dedup key claimed for each of them ("" when the point has no ID).
+// Store errors fail open: publishing a duplicate is better than losing a point,
+// and the consumer's unique key catches it downstream.
+//
+// A point another attempt is still publishing fails the batch with
+// ErrPublishPending, releasing the claims taken so far: it can't be
+// acknowledged as a duplicate before that attempt succeeded.
+func (p *Pipeline) deduplicate(ctx context.Context, points []TrackPoint) ([]TrackPoint, []string, error) {
+	if p.dedup == nil {
+		return points, make([]string, len(points)), nil
+	}
+
+	fresh := make([]TrackPoint, 0, len(points))
+	claimed := make([]string, 0, len(points))
+	for _, tp := range points {
+		key, ok := dedupKey(tp)
+		if !ok {
+			fresh = append(fresh, tp)
+			claimed = append(claimed, "")
+			continue
+		}
+		state, err := p.dedup.Claim(ctx, key)
+		if err != nil {
+			slog.Warn("dedup claim failed", "error", err, "container_id", tp.ContainerID)
+			fresh = append(fresh, tp)
+			claimed = append(claimed, "")
+			continue
+		}
+		switch state {
+		case ClaimDone:
+			continue
+		case ClaimPending:
+			p.release(ctx, claimed)
+			return nil, nil, ErrPublishPending
+		}
+		fresh = append(fresh, tp)
+		claimed = append(claimed, key)
+	}
+	return fresh, claimed, nil
+}
+
+// confirm marks the claimed keys as published.
+func (p *Pipeline) confirm(ctx context.Context, keys []string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dedupCleanupTimeout)
+	defer cancel()
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := p.dedup.Confirm(ctx, key); err != nil {
+			slog.Warn("dedup confirm failed", "error", err, "key", key)
+		}
+	}
+}
+
+// release forgets the claimed keys. The write may have failed because ctx
+// is done, so the store is called without its cancellation.
+func (p *Pipeline) release(ctx context.Context, keys []string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dedupCleanupTimeout)
+	defer cancel()
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := p.dedup.Release(ctx, key); err != nil {
+			slog.Warn("dedup release failed", "error", err, "key", key)
+		}
+	}
+}
+
+// rateLimitKeys returns the buckets a request is charged to: the device key
+// when authenticated, otherwise every container in the batch.
+func rateLimitKeys(device DeviceKey, authenticated bool, points []TrackPoint) []string {
+	if authenticated {
+		return []string{"key:" + device.ID}
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, tp := range points {
+		id := iso6346.Normalize(tp.ContainerID)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, "container:"+id)
+	}
+	return keys
+}
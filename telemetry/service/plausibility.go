@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PlausibilityConfig sets the thresholds of the plausibility stage.
+type PlausibilityConfig struct {
+	// MaxSpeed is the highest speed in m/s implied by two consecutive fixes
+	// of a container. Container ships and trains stay well below 50 m/s.
+	MaxSpeed float64
+	// MaxFutureSkew is how far ahead of the server clock a timestamp may be.
+	MaxFutureSkew time.Duration
+}
+
+// DefaultPlausibilityConfig allows for road, rail and sea freight with room
+// for GPS jitter.
+var DefaultPlausibilityConfig = PlausibilityConfig{
+	MaxSpeed:      100,
+	MaxFutureSkew: 5 * time.Minute,
+}
+
+const (
+	earthRadiusMeters = 6371000
+	// Fixes within ~10m of (0,0) are almost always a receiver without a fix
+	// reporting zero values, not a buoy in the Gulf of Guinea.
+	nullIslandDegrees = 0.0001
+	// Speed between fixes closer than this is dominated by GPS jitter.
+	minJumpMeters = 1000
+	fixIdleTTL    = 24 * time.Hour
+	// After this many jumps in a row the stored fix was probably the outlier,
+	// so the next point replaces it instead of being flagged forever.
+	maxJumpStrikes = 3
+)
+
+// Plausibility keeps the last accepted fix of every container and flags
+// points that can't be real: null-island coordinates, timestamps in the
+// future, and jumps implying impossible speeds. State is per replica.
+type Plausibility struct {
+	cfg PlausibilityConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	last      map[string]lastFix
+	lastSweep time.Time
+}
+
+type lastFix struct {
+	lat, lon float64
+	at       time.Time
+	seen     time.Time
+	strikes  int
+}
+
+// NewPlausibility creates a plausibility stage with cfg.
+func NewPlausibility(cfg PlausibilityConfig) *Plausibility {
+	return &Plausibility{
+		cfg:       cfg,
+		now:       time.Now,
+		last:      make(map[string]lastFix),
+		lastSweep: time.Now(),
+	}
+}
+
+// Check returns why tp is implausible, or "" if it looks real. Plausible
+// points become the container's last fix when they are newer than it.
+// Points older than the last fix (a device flushing its buffer out of order)
+// are checked against it too, but their jumps don't count as strikes: they
+// say nothing about whether the last fix was the outlier.
+func (p *Plausibility) Check(tp TrackPoint) string {
+	now := p.now()
+	if math.Abs(tp.Lat) < nullIslandDegrees && math.Abs(tp.Lon) < nullIslandDegrees {
+		return "null island coordinates"
+	}
+	if tp.Timestamp.After(now.Add(p.cfg.MaxFutureSkew)) {
+		return "timestamp too far in the future"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweep(now)
+
+	prev, ok := p.last[tp.ContainerID]
+	if ok {
+		dist := distanceMeters(prev.lat, prev.lon, tp.Lat, tp.Lon)
+		dt := math.Abs(tp.Timestamp.Sub(prev.at).Seconds())
+		late := tp.Timestamp.Before(prev.at)
+		if dist > minJumpMeters && (dt == 0 || dist/dt > p.cfg.MaxSpeed) && (late || prev.strikes+1 < maxJumpStrikes) {
+			if !late {
+				prev.strikes++
+				p.last[tp.ContainerID] = prev
+			}
+			return fmt.Sprintf("implied speed exceeds %.0f m/s (%.0f km jump)", p.cfg.MaxSpeed, dist/1000)
+		}
+	}
+	if !ok || tp.Timestamp.After(prev.at) {
+		p.last[tp.ContainerID] = lastFix{lat: tp.Lat, lon: tp.Lon, at: tp.Timestamp, seen: now}
+	}
+	return ""
+}
+
+// sweep drops containers not heard from in a day. Called with p.mu held.
+func (p *Plausibility) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < time.Minute {
+		return
+	}
+	for id, fix := range p.last {
+		if now.Sub(fix.seen) > fixIdleTTL {
+			delete(p.last, id)
+		}
+	}
+	p.lastSweep = now
+}
+
+// distanceMeters is the haversine great-circle distance.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPlausibility_Check(t *testing.T) {
+	now := time.Date(2026, 1, 23, 10, 0, 0, 0, time.UTC)
+	at := func(lat, lon float64, offset time.Duration) TrackPoint {
+		return TrackPoint{ContainerID: "MSKU1234567", Lat: lat, Lon: lon, Timestamp: now.Add(offset)}
+	}
+
+	tests := []struct {
+		name     string
+		sequence []TrackPoint
+		// wantFlag is whether the last point of the sequence is flagged
+		wantFlag bool
+	}{
+		{"first fix", []TrackPoint{at(51.92, 4.48, 0)}, false},
+		{"null island", []TrackPoint{at(0, 0, 0)}, true},
+		{"future timestamp", []TrackPoint{at(51.92, 4.48, time.Hour)}, true},
+		{"small clock skew", []TrackPoint{at(51.92, 4.48, time.Minute)}, false},
+		{"truck speed", []TrackPoint{at(51.92, 4.48, -time.Hour), at(51.44, 5.47, 0)}, false},
+		{"rotterdam to new york in a minute", []TrackPoint{at(51.92, 4.48, -time.Minute), at(40.68, -74.04, 0)}, true},
+		{"jump with same timestamp", []TrackPoint{at(51.92, 4.48, 0), at(52.37, 4.90, 0)}, true},
+		{"jitter with same timestamp", []TrackPoint{at(51.92, 4.48, 0), at(51.9201, 4.4801, 0)}, false},
+		{"late point from buffer", []TrackPoint{at(51.92, 4.48, 0), at(51.44, 5.47, -time.Hour)}, false},
+		{"back-dated teleport", []TrackPoint{at(51.92, 4.48, 0), at(40.68, -74.04, -time.Hour)}, true},
+		{"back-dated teleports are no strikes", []TrackPoint{
+			at(51.92, 4.48, 0),
+			at(40.68, -74.04, -3*time.Minute),
+			at(40.68, -74.04, -2*time.Minute),
+			at(40.68, -74.04, -time.Minute),
+		}, true},
+		{"stored fix was the outlier", []TrackPoint{
+			at(40.68, -74.04, -3*time.Minute), // bad fix
+			at(51.92, 4.48, -2*time.Minute),
+			at(51.92, 4.48, -time.Minute),
+			at(51.92, 4.48, 0),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPlausibility(DefaultPlausibilityConfig)
+			p.now = func() time.Time { return now }
+
+			var reason string
+			for _, tp := range tt.sequence {
+				reason = p.Check(tp)
+			}
+			if (reason != "") != tt.wantFlag {
+				t.Errorf("got reason %q, wantFlag %v", reason, tt.wantFlag)
+			}
+		})
+	}
+}
+
+func TestHandler_QuarantinesImplausiblePoints(t *testing.T) {
+	published := &mockProducer{}
+	quarantine := &mockProducer{}
+	h := NewHandler(NewPipeline(published, WithPlausibility(NewPlausibility(DefaultPlausibilityConfig), quarantine)))
+
+	bad := validTrackPoint()
+	bad.Lat, bad.Lon = 0, 0
+	rec := postPoints(h, []TrackPoint{validTrackPoint(), bad})
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if len(published.written) != 1 {
+		t.Errorf("got %d points on main topic, want 1", len(published.written))
+	}
+	if len(quarantine.written) != 1 || quarantine.written[0].QuarantineReason == "" {
+		t.Errorf("got quarantined %+v, want one point with a reason", quarantine.written)
+	}
+}
+
+func TestHandler_RejectsImplausiblePointsWithoutQuarantine(t *testing.T) {
+	published := &mockProducer{}
+	h := NewHandler(NewPipeline(published, WithPlausibility(NewPlausibility(DefaultPlausibilityConfig), nil)))
+
+	bad := validTrackPoint()
+	bad.Timestamp = time.Now().Add(24 * time.Hour)
+	rec := postPoints(h, []TrackPoint{{}, bad, validTrackPoint()})
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+	if len(published.written) != 1 {
+		t.Errorf("got %d written points, want 1", len(published.written))
+	}
+	var result BatchResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if len(result.Rejected) != 2 || result.Rejected[0].Index != 0 || result.Rejected[1].Index != 1 {
+		t.Errorf("got rejected %+v, want indexes 0 and 1", result.Rejected)
+	}
+}
@@ -2,42 +2,140 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/lai/logistics/pkg/contracts"
+	"github.com/lai/logistics/pkg/tracing"
 	"github.com/segmentio/kafka-go"
 )
 
+// producerName identifies this service in message envelopes.
+const producerName = "telemetry"
+
+// ErrProducerSaturated is returned instead of blocking when too many
+// messages are waiting for delivery, e.g. while Kafka is slow or down.
+var ErrProducerSaturated = errors.New("producer buffer saturated")
+
+// DeliveryMode selects the trade-off between latency and durability.
+type DeliveryMode string
+
+const (
+	// DeliveryAsync buffers messages and returns before Kafka acknowledged
+	// them (acks=1). Failures only show up in OnDelivery.
+	DeliveryAsync DeliveryMode = "async"
+	// DeliverySync blocks Write until every in-sync replica has the message
+	// (acks=all), so a nil error means the point is durable.
+	DeliverySync DeliveryMode = "sync"
+)
+
+// ParseDeliveryMode parses a config value.
+func ParseDeliveryMode(s string) (DeliveryMode, error) {
+	switch mode := DeliveryMode(s); mode {
+	case DeliveryAsync, DeliverySync:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown delivery mode %q", s)
+	}
+}
+
+// KafkaProducerConfig configures NewKafkaProducer.
+type KafkaProducerConfig struct {
+	Brokers []string
+	Topic   string
+	Mode    DeliveryMode // default DeliveryAsync
+	// MaxInFlight caps messages buffered awaiting delivery; 0 means unlimited.
+	MaxInFlight int
+	// OnDelivery is called for every batch Kafka acknowledged (err nil) or
+	// the writer gave up on. In async mode this is the only place delivery
+	// failures are visible.
+	OnDelivery func(messages int, err error)
+}
+
 // Producer writes TrackPoints to Kafka in batches.
 type KafkaProducer struct {
-	writer *kafka.Writer
+	writer      *kafka.Writer
+	inFlight    atomic.Int64
+	maxInFlight int64
 }
 
-// NewProducer creates a producer for the given topic.
-func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
-	w := &kafka.Writer{
-		Addr:                   kafka.TCP(brokers...),
-		Topic:                  topic,
-		Balancer:               &kafka.LeastBytes{},
+// NewKafkaProducer creates a producer from cfg.
+func NewKafkaProducer(cfg KafkaProducerConfig) *KafkaProducer {
+	p := &KafkaProducer{maxInFlight: int64(cfg.MaxInFlight)}
+	p.writer = &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Brokers...),
+		Topic:                  cfg.Topic,
+		Balancer:               &kafka.Hash{}, // by container, so its points stay in order
 		BatchSize:              100,
 		BatchTimeout:           10 * time.Millisecond,
 		Async:                  true,
 		RequiredAcks:           kafka.RequireOne,
 		AllowAutoTopicCreation: true,
+		// Called once a batch was delivered or given up on
+		Completion: func(messages []kafka.Message, err error) {
+			produceDuration.WithLabelValues(cfg.Topic).Observe(time.Since(messages[0].Time).Seconds())
+			if err != nil {
+				produceErrors.WithLabelValues(cfg.Topic).Add(float64(len(messages)))
+			}
+			if p.writer.Async {
+				p.inFlight.Add(-int64(len(messages)))
+			}
+			if cfg.OnDelivery != nil {
+				cfg.OnDelivery(len(messages), err)
+			}
+		},
+	}
+	if cfg.Mode == DeliverySync {
+		p.writer.Async = false
+		p.writer.RequiredAcks = kafka.RequireAll
 	}
-	return &KafkaProducer{writer: w}
+	return p
 }
 
-// Write sends a TrackPoint to Kafka.
+// Write sends a TrackPoint to Kafka. In DeliverySync mode it returns once
+// the point is acknowledged by all in-sync replicas.
 func (p *KafkaProducer) Write(ctx context.Context, tp TrackPoint) error {
-	data, err := json.Marshal(tp)
-	if err != nil {
-		return err
+	return p.WriteBatch(ctx, []TrackPoint{tp})
+}
+
+// WriteBatch sends points with a single WriteMessages call, so the writer
+// batches them per partition instead of once per point. Each point is
+// wrapped in a contracts.Envelope and carries the trace context of ctx.
+// In async mode the send span ends once the points are buffered.
+func (p *KafkaProducer) WriteBatch(ctx context.Context, points []TrackPoint) (err error) {
+	ctx, span := tracing.StartSend(ctx, p.writer.Topic, len(points))
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now() // also read back by Completion for the produce latency
+	trace := tracing.Envelope(ctx)
+	msgs := make([]kafka.Message, len(points))
+	for i, tp := range points {
+		data, err := contracts.Marshal(contracts.TypeTrackPoint, producerName, tp.Timestamp, trace, tp)
+		if err != nil {
+			return err
+		}
+		msgs[i] = kafka.Message{
+			Key:   []byte(tp.ContainerID),
+			Value: data,
+			Time:  now,
+		}
+		tracing.Inject(ctx, &msgs[i])
+	}
+
+	n := int64(len(msgs))
+	if total := p.inFlight.Add(n); p.maxInFlight > 0 && total > p.maxInFlight {
+		p.inFlight.Add(-n)
+		return ErrProducerSaturated
+	}
+	err = p.writer.WriteMessages(ctx, msgs...)
+	// Async writes are counted down by Completion, except when they were
+	// never enqueued. Sync writes are done (or abandoned) on return.
+	if err != nil || !p.writer.Async {
+		p.inFlight.Add(-n)
 	}
-	return p.writer.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(tp.ContainerID),
-		Value: data,
-	})
+	return err
 }
 
 // Close flushes pending messages and closes the connection.
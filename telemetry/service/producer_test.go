@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestNewKafkaProducer_DeliveryMode(t *testing.T) {
+	tests := []struct {
+		mode      DeliveryMode
+		wantAsync bool
+		wantAcks  kafka.RequiredAcks
+	}{
+		{"", true, kafka.RequireOne},
+		{DeliveryAsync, true, kafka.RequireOne},
+		{DeliverySync, false, kafka.RequireAll},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			p := NewKafkaProducer(KafkaProducerConfig{Brokers: []string{"localhost:9092"}, Topic: "t", Mode: tt.mode})
+			defer p.Close()
+
+			if p.writer.Async != tt.wantAsync {
+				t.Errorf("got Async %v, want %v", p.writer.Async, tt.wantAsync)
+			}
+			if p.writer.RequiredAcks != tt.wantAcks {
+				t.Errorf("got RequiredAcks %v, want %v", p.writer.RequiredAcks, tt.wantAcks)
+			}
+		})
+	}
+}
+
+func TestParseDeliveryMode(t *testing.T) {
+	if mode, err := ParseDeliveryMode("sync"); err != nil || mode != DeliverySync {
+		t.Errorf("got %q, %v, want %q", mode, err, DeliverySync)
+	}
+	if _, err := ParseDeliveryMode("exactly-once"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig sets the token buckets. A zero rate disables that limit.
+type RateLimitConfig struct {
+	// DeviceRate is requests per second per device key, or per container
+	// when requests aren't authenticated.
+	DeviceRate  float64
+	DeviceBurst int
+	// GlobalRate is points per second across all devices, sized to what the
+	// Kafka topic can absorb.
+	GlobalRate  float64
+	GlobalBurst int
+}
+
+// Limiters idle for this long are dropped, so one-off container IDs don't
+// accumulate forever.
+const limiterIdleTTL = 10 * time.Minute
+
+// RateLimiter holds a token bucket per device and one global bucket.
+type RateLimiter struct {
+	cfg    RateLimitConfig
+	global *rate.Limiter
+
+	mu        sync.Mutex
+	devices   map[string]*deviceLimiter
+	lastSweep time.Time
+}
+
+type deviceLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a limiter from cfg.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	l := &RateLimiter{
+		cfg:       cfg,
+		devices:   make(map[string]*deviceLimiter),
+		lastSweep: time.Now(),
+	}
+	if cfg.GlobalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(cfg.GlobalRate), max(cfg.GlobalBurst, 1))
+	}
+	return l
+}
+
+// AllowDevices takes one token from the bucket of every key. If any bucket
+// is empty nothing is taken, and the wait until all of them have a token is
+// returned.
+func (l *RateLimiter) AllowDevices(keys []string) (bool, time.Duration) {
+	if l.cfg.DeviceRate <= 0 || len(keys) == 0 {
+		return true, 0
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	reservations := make([]*rate.Reservation, 0, len(keys))
+	var wait time.Duration
+	for _, key := range keys {
+		dl, ok := l.devices[key]
+		if !ok {
+			dl = &deviceLimiter{limiter: rate.NewLimiter(rate.Limit(l.cfg.DeviceRate), max(l.cfg.DeviceBurst, 1))}
+			l.devices[key] = dl
+		}
+		dl.lastSeen = now
+		r := dl.limiter.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		wait = max(wait, r.DelayFrom(now))
+	}
+	if wait == 0 {
+		return true, 0
+	}
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	return false, wait
+}
+
+// AllowPoints takes n tokens from the global bucket. Batches larger than the
+// burst are charged the full burst, so they can still get through.
+func (l *RateLimiter) AllowPoints(n int) (bool, time.Duration) {
+	if l.global == nil || n == 0 {
+		return true, 0
+	}
+	now := time.Now()
+	r := l.global.ReserveN(now, min(n, l.global.Burst()))
+	if wait := r.DelayFrom(now); wait > 0 {
+		r.CancelAt(now)
+		return false, wait
+	}
+	return true, 0
+}
+
+// sweep drops idle device limiters. Called with l.mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	for key, dl := range l.devices {
+		if now.Sub(dl.lastSeen) > limiterIdleTTL {
+			delete(l.devices, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for Retry-After.
+func retryAfterSeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func postPoints(h http.Handler, points []TrackPoint) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(points)
+	req := httptest.NewRequest(http.MethodPost, "/api/track", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_DeviceRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{DeviceRate: 0.1, DeviceBurst: 2})
+	h := NewHandler(NewPipeline(&mockProducer{}, WithRateLimit(limiter)))
+
+	for i := range 2 {
+		if rec := postPoints(h, []TrackPoint{validTrackPoint()}); rec.Code != http.StatusAccepted {
+			t.Fatalf("request %d: got status %d, want %d", i, rec.Code, http.StatusAccepted)
+		}
+	}
+
+	rec := postPoints(h, []TrackPoint{validTrackPoint()})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if secs, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || secs < 1 || secs > 10 {
+		t.Errorf("got Retry-After %q, want 1-10 seconds", rec.Header().Get("Retry-After"))
+	}
+
+	// Other containers have their own bucket
+	other := validTrackPoint()
+	other.ContainerID = "TGHU7654321"
+	if rec := postPoints(h, []TrackPoint{other}); rec.Code != http.StatusAccepted {
+		t.Errorf("other container: got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+}
+
+func TestHandler_GlobalRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{GlobalRate: 1, GlobalBurst: 3})
+	mock := &mockProducer{}
+	h := NewHandler(NewPipeline(mock, WithRateLimit(limiter)))
+
+	batch := []TrackPoint{validTrackPoint(), validTrackPoint()}
+	if rec := postPoints(h, batch); rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	rec := postPoints(h, batch)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if len(mock.written) != 2 {
+		t.Errorf("got %d written points, want 2", len(mock.written))
+	}
+}
+
+func TestHandler_ShedsLoadWhenProducerSaturated(t *testing.T) {
+	h := NewHandler(NewPipeline(&mockProducer{err: ErrProducerSaturated}))
+
+	rec := postPoints(h, []TrackPoint{validTrackPoint()})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q, want %q", rec.Header().Get("Retry-After"), "1")
+	}
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolFull is returned when the spool reached its disk cap.
+var ErrSpoolFull = errors.New("spool full")
+
+const (
+	spoolSegmentBytes = 8 << 20 // rotate segments so replayed data can be deleted
+	spoolExt          = ".spool"
+	replayBatchSize   = 500
+)
+
+// Spool is an append-only on-disk queue of TrackPoints, stored as JSON lines
+// in numbered segment files. Points are fsynced before Append returns.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []spoolSegment // oldest first; the last one may be active
+	active   *os.File
+	points   int
+	bytes    int64
+}
+
+type spoolSegment struct {
+	seq    uint64
+	points int
+	bytes  int64
+}
+
+// OpenSpool opens the spool in dir, picking up segments left by a previous
+// run. maxBytes caps the total size on disk.
+func OpenSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+	for _, e := range entries {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		seg, err := s.scanSegment(seq)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.points += seg.points
+		s.bytes += seg.bytes
+	}
+	slices.SortFunc(s.segments, func(a, b spoolSegment) int { return cmp.Compare(a.seq, b.seq) })
+	return s, nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+func (s *Spool) scanSegment(seq uint64) (spoolSegment, error) {
+	f, err := os.Open(s.path(seq))
+	if err != nil {
+		return spoolSegment{}, err
+	}
+	defer f.Close()
+
+	seg := spoolSegment{seq: seq}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		seg.points++
+		seg.bytes += int64(len(scanner.Bytes())) + 1
+	}
+	return seg, scanner.Err()
+}
+
+// Depth returns the number of spooled points and their size on disk.
+func (s *Spool) Depth() (points int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.points, s.bytes
+}
+
+// Append durably adds points to the end of the spool.
+func (s *Spool) Append(points ...TrackPoint) error {
+	var buf []byte
+	for _, tp := range points {
+		line, err := json.Marshal(tp)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bytes+int64(len(buf)) > s.maxBytes {
+		return ErrSpoolFull
+	}
+	if s.active == nil || s.segments[len(s.segments)-1].bytes >= spoolSegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.active.Write(buf); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+
+	seg := &s.segments[len(s.segments)-1]
+	seg.points += len(points)
+	seg.bytes += int64(len(buf))
+	s.points += len(points)
+	s.bytes += int64(len(buf))
+	return nil
+}
+
+// rotate seals the active segment and starts a new one. Called with s.mu held.
+func (s *Spool) rotate() error {
+	s.seal()
+	var seq uint64 = 1
+	if n := len(s.segments); n > 0 {
+		seq = s.segments[n-1].seq + 1
+	}
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, spoolSegment{seq: seq})
+	return nil
+}
+
+// seal closes the active segment. Called with s.mu held.
+func (s *Spool) seal() {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+}
+
+// oldest returns the oldest segment for replay, sealing it first if it is
+// still being appended to.
+func (s *Spool) oldest() (spoolSegment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		return spoolSegment{}, false
+	}
+	if len(s.segments) == 1 {
+		s.seal()
+	}
+	return s.segments[0], true
+}
+
+// remove deletes a fully replayed segment.
+func (s *Spool) remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 || s.segments[0].seq != seq {
+		return fmt.Errorf("spool: segment %d is not the oldest", seq)
+	}
+	if err := os.Remove(s.path(seq)); err != nil {
+		return err
+	}
+	s.points -= s.segments[0].points
+	s.bytes -= s.segments[0].bytes
+	s.segments = s.segments[1:]
+	return nil
+}
+
+// read returns the points of a sealed segment.
+func (s *Spool) read(seq uint64) ([]TrackPoint, error) {
+	f, err := os.Open(s.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []TrackPoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var tp TrackPoint
+		if err := json.Unmarshal(scanner.Bytes(), &tp); err != nil {
+			// A torn write from a crash; the rest of the segment is still good
+			slog.Warn("skipping corrupt spool record", "segment", seq, "error", err)
+			continue
+		}
+		points = append(points, tp)
+	}
+	return points, scanner.Err()
+}
+
+// Close releases the active segment file.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seal()
+	return nil
+}
+
+// SpoolingProducer writes through to another Producer and spools points to
+// disk when that fails, so an accepted point survives a Kafka outage. Once
+// anything is spooled, new points queue behind it until Run has replayed
+// the backlog, which keeps each container's points in order.
+type SpoolingProducer struct {
+	next  Producer
+	spool *Spool
+	retry time.Duration
+}
+
+// ErrAsyncSpool is returned by NewSpoolingProducer for a KafkaProducer in
+// DeliveryAsync mode: its writes succeed before Kafka has the points, so a
+// broker outage would drop them in the writer instead of reaching the spool.
+var ErrAsyncSpool = errors.New("spool requires sync kafka delivery")
+
+// NewSpoolingProducer wraps next with spool. next must only return nil
+// once the points are delivered.
+func NewSpoolingProducer(next Producer, spool *Spool) (*SpoolingProducer, error) {
+	if kp, ok := next.(*KafkaProducer); ok && kp.writer.Async {
+		return nil, ErrAsyncSpool
+	}
+	return &SpoolingProducer{next: next, spool: spool, retry: time.Second}, nil
+}
+
+func (p *SpoolingProducer) Write(ctx context.Context, tp TrackPoint) error {
+	return p.WriteBatch(ctx, []TrackPoint{tp})
+}
+
+// WriteBatch spools the whole batch if the write fails. Some of it may have
+// reached Kafka anyway; the replay repeats those and the consumer drops them
+// by point_id.
+func (p *SpoolingProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	if depth, _ := p.spool.Depth(); depth == 0 {
+		err := p.next.WriteBatch(ctx, points)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("kafka write failed, spooling to disk", "error", err, "count", len(points))
+	}
+	return p.spool.Append(points...)
+}
+
+// Run replays the spool until ctx is cancelled. Replay is at-least-once: a
+// crash mid-segment replays that segment again, and the consumer drops the
+// repeats by point_id.
+func (p *SpoolingProducer) Run(ctx context.Context) {
+	for {
+		if err := p.drain(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("spool replay paused", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// drain replays segments oldest first until the spool is empty or ctx is
+// cancelled.
+func (p *SpoolingProducer) drain(ctx context.Context) error {
+	for {
+		seg, ok := p.spool.oldest()
+		if !ok {
+			return nil
+		}
+		points, err := p.spool.read(seg.seq)
+		if err != nil {
+			return err
+		}
+		if err := p.replay(ctx, seg.seq, points); err != nil {
+			return err
+		}
+		if err := p.spool.remove(seg.seq); err != nil {
+			return err
+		}
+		remaining, _ := p.spool.Depth()
+		slog.Info("spool segment replayed", "segment", seg.seq, "points", len(points), "remaining", remaining)
+	}
+}
+
+// replay writes points in chunks, retrying a failed chunk so order is kept.
+func (p *SpoolingProducer) replay(ctx context.Context, seq uint64, points []TrackPoint) error {
+	for len(points) > 0 {
+		chunk := points[:min(len(points), replayBatchSize)]
+		if err := p.next.WriteBatch(ctx, chunk); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			slog.Warn("spool replay write failed", "error", err, "segment", seq)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.retry):
+			}
+			continue
+		}
+		points = points[len(chunk):]
+	}
+	return nil
+}
+
+func (p *SpoolingProducer) Close() error {
+	p.spool.Close()
+	return p.next.Close()
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProducer fails every write while down is set.
+type flakyProducer struct {
+	mu      sync.Mutex
+	down    bool
+	written []TrackPoint
+}
+
+func (f *flakyProducer) Write(ctx context.Context, tp TrackPoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("kafka unavailable")
+	}
+	f.written = append(f.written, tp)
+	return nil
+}
+
+func (f *flakyProducer) WriteBatch(ctx context.Context, points []TrackPoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("kafka unavailable")
+	}
+	f.written = append(f.written, points...)
+	return nil
+}
+
+func (f *flakyProducer) Close() error {
+	return nil
+}
+
+func (f *flakyProducer) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyProducer) points() []TrackPoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TrackPoint(nil), f.written...)
+}
+
+func spoolPoint(id string) TrackPoint {
+	tp := validTrackPoint()
+	tp.PointID = id
+	return tp
+}
+
+func TestSpoolingProducer_ReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kafka := &flakyProducer{down: true}
+	p, err := NewSpoolingProducer(kafka, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.retry = 10 * time.Millisecond
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2"} {
+		if err := p.Write(ctx, spoolPoint(id)); err != nil {
+			t.Fatalf("write %s: %v", id, err)
+		}
+	}
+	// Kafka is back, but point 3 must queue behind the backlog
+	kafka.setDown(false)
+	if err := p.Write(ctx, spoolPoint("3")); err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := spool.Depth(); depth != 3 {
+		t.Fatalf("got depth %d, want 3", depth)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		p.Run(runCtx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(kafka.points()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	var got []string
+	for _, tp := range kafka.points() {
+		got = append(got, tp.PointID)
+	}
+	if len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Errorf("got replay order %v, want [1 2 3]", got)
+	}
+	if depth, bytes := spool.Depth(); depth != 0 || bytes != 0 {
+		t.Errorf("got depth %d (%d bytes), want empty spool", depth, bytes)
+	}
+
+	// Drained, so writes go straight through again
+	if err := p.Write(ctx, spoolPoint("4")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(kafka.points()); n != 4 {
+		t.Errorf("got %d written points, want 4", n)
+	}
+}
+
+func TestSpool_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolPoint("a"), spoolPoint("b")); err != nil {
+		t.Fatal(err)
+	}
+	spool.Close()
+
+	reopened, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := reopened.Depth(); depth != 2 {
+		t.Fatalf("got depth %d after reopen, want 2", depth)
+	}
+	if err := reopened.Append(spoolPoint("c")); err != nil {
+		t.Fatal(err)
+	}
+	seg, ok := reopened.oldest()
+	if !ok {
+		t.Fatal("expected a segment")
+	}
+	points, err := reopened.read(seg.seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].PointID != "a" {
+		t.Errorf("got oldest segment %+v, want points a, b", points)
+	}
+}
+
+func TestSpool_Cap(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var err2 error
+	for i := 0; i < 10 && err2 == nil; i++ {
+		err2 = spool.Append(spoolPoint("x"))
+	}
+	if !errors.Is(err2, ErrSpoolFull) {
+		t.Errorf("got %v, want ErrSpoolFull", err2)
+	}
+	if _, bytes := spool.Depth(); bytes > 300 {
+		t.Errorf("got %d bytes spooled, want <= 300", bytes)
+	}
+}
+
+func TestSpoolingProducer_RequiresSyncKafka(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	// Async writes succeed once buffered, so a broker failing after that
+	// would never reach the spool
+	async := NewKafkaProducer(KafkaProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "t", Mode: DeliveryAsync})
+	defer async.Close()
+	if _, err := NewSpoolingProducer(async, spool); !errors.Is(err, ErrAsyncSpool) {
+		t.Errorf("async: got %v, want %v", err, ErrAsyncSpool)
+	}
+
+	// Nothing listens here, so the sync write fails and is spooled
+	sync := NewKafkaProducer(KafkaProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "t", Mode: DeliverySync})
+	defer sync.Close()
+	p, err := NewSpoolingProducer(sync, spool)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if err := p.Write(context.Background(), spoolPoint("1")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if depth, _ := spool.Depth(); depth != 1 {
+		t.Errorf("got spool depth %d, want 1", depth)
+	}
+}
@@ -0,0 +1,175 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+// GT06 packet types we act on.
+const (
+	gt06Login     = 0x01
+	gt06Location  = 0x12
+	gt06Heartbeat = 0x13
+	gt06Alarm     = 0x16
+	gt06LocationN = 0x22 // GT06N GPS+LBS
+)
+
+// GT06 decodes the binary protocol used by Concox GT06 and the many cheap
+// trackers cloning it:
+//
+//	0x78 0x78 | len | type | payload | serial(2) | crc(2) | 0x0D 0x0A
+//
+// The device logs in with its IMEI and must receive an ACK before it sends
+// positions. Extended packets (0x79 0x79, two-byte length) are framed but
+// not decoded.
+type GT06 struct{}
+
+func (GT06) Name() string { return "gt06" }
+
+func (GT06) Split(data []byte, atEOF bool) (int, []byte, error) {
+	// Skip noise until a start marker
+	start := -1
+	for i := 0; i+1 < len(data); i++ {
+		if (data[i] == 0x78 && data[i+1] == 0x78) || (data[i] == 0x79 && data[i+1] == 0x79) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		// Keep a trailing byte that may be half of a marker
+		return max(len(data)-1, 0), nil, nil
+	}
+
+	frame := data[start:]
+	var total int
+	if frame[0] == 0x78 {
+		if len(frame) < 3 {
+			return start, nil, nil
+		}
+		total = 2 + 1 + int(frame[2]) + 2
+	} else {
+		if len(frame) < 4 {
+			return start, nil, nil
+		}
+		total = 2 + 2 + int(binary.BigEndian.Uint16(frame[2:4])) + 2
+	}
+	if len(frame) < total {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return start, nil, nil
+	}
+	return start + total, frame[:total], nil
+}
+
+func (GT06) Decode(sess *Session, frame []byte) (Result, error) {
+	if frame[0] != 0x78 {
+		return Result{}, nil
+	}
+	if len(frame) < 10 || frame[len(frame)-2] != 0x0D || frame[len(frame)-1] != 0x0A {
+		return Result{}, errors.New("gt06: malformed packet")
+	}
+
+	// CRC covers length byte through serial number
+	body := frame[2 : len(frame)-4]
+	if got, want := crcITU(body), binary.BigEndian.Uint16(frame[len(frame)-4:]); got != want {
+		return Result{}, fmt.Errorf("gt06: crc mismatch: got %04X, want %04X", got, want)
+	}
+
+	kind := body[1]
+	payload := body[2 : len(body)-2]
+	serial := body[len(body)-2:]
+
+	switch kind {
+	case gt06Login:
+		if len(payload) < 8 {
+			return Result{}, errors.New("gt06: short login packet")
+		}
+		// IMEI as 8 BCD bytes with a leading zero nibble
+		sess.DeviceID = strings.TrimLeft(hex.EncodeToString(payload[:8]), "0")
+		return Result{Reply: gt06Ack(kind, serial)}, nil
+	case gt06Heartbeat:
+		return Result{Reply: gt06Ack(kind, serial)}, nil
+	case gt06Location, gt06LocationN, gt06Alarm:
+		if sess.DeviceID == "" {
+			return Result{}, errors.New("gt06: location before login")
+		}
+		tp, ok, err := parseGT06Location(payload)
+		if err != nil || !ok {
+			return Result{}, err
+		}
+		res := Result{Points: []service.TrackPoint{tp}}
+		if kind == gt06Alarm {
+			res.Reply = gt06Ack(kind, serial)
+		}
+		return res, nil
+	default:
+		return Result{}, nil
+	}
+}
+
+// parseGT06Location decodes the GPS block shared by location and alarm
+// packets:
+//
+//	date(6) | gps info(1) | lat(4) | lon(4) | speed(1) | course/status(2)
+//
+// ok is false when the device reports no GPS fix.
+func parseGT06Location(p []byte) (tp service.TrackPoint, ok bool, err error) {
+	if len(p) < 18 {
+		return tp, false, errors.New("gt06: short location packet")
+	}
+
+	tp.Timestamp = time.Date(2000+int(p[0]), time.Month(p[1]), int(p[2]),
+		int(p[3]), int(p[4]), int(p[5]), 0, time.UTC)
+
+	status := binary.BigEndian.Uint16(p[16:18])
+	if status&0x1000 == 0 { // bit 12: GPS positioned
+		return tp, false, nil
+	}
+
+	// Coordinates are in units of 1/30000 arc minute
+	tp.Lat = float64(binary.BigEndian.Uint32(p[7:11])) / 30000 / 60
+	tp.Lon = float64(binary.BigEndian.Uint32(p[11:15])) / 30000 / 60
+	if status&0x0400 == 0 { // bit 10: north latitude
+		tp.Lat = -tp.Lat
+	}
+	if status&0x0800 != 0 { // bit 11: west longitude
+		tp.Lon = -tp.Lon
+	}
+	tp.Speed = float64(p[15]) / 3.6     // km/h to m/s
+	heading := float64(status & 0x03FF) // bits 0-9: course in degrees
+	tp.Heading = &heading
+	return tp, true, nil
+}
+
+// gt06Ack builds the server response echoing the packet type and serial.
+func gt06Ack(kind byte, serial []byte) []byte {
+	ack := []byte{0x78, 0x78, 0x05, kind, serial[0], serial[1], 0, 0, 0x0D, 0x0A}
+	binary.BigEndian.PutUint16(ack[6:8], crcITU(ack[2:6]))
+	return ack
+}
+
+// crcITU is CRC-16/X-25 as specified by the GT06 protocol.
+func crcITU(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
@@ -0,0 +1,164 @@
+package tracker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+const knotsToMetersPerSecond = 0.514444
+
+// NMEA decodes NMEA 0183 sentences, one per line.
+//
+// NMEA itself carries no device identity, so tracker firmware prefixes the
+// sentence with its ID: "861234567890123,$GPRMC,...". On TCP the prefix only
+// needs to be sent once; later sentences on the connection reuse it.
+//
+// Positions come from RMC, the only common sentence with date, speed and
+// fix status. GGA repeats the same fix without a date and is ignored.
+type NMEA struct{}
+
+func (NMEA) Name() string { return "nmea" }
+
+func (NMEA) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return bufio.ScanLines(data, atEOF)
+}
+
+func (NMEA) Decode(sess *Session, frame []byte) (Result, error) {
+	line := strings.TrimSpace(string(frame))
+	if line == "" {
+		return Result{}, nil
+	}
+
+	start := strings.IndexByte(line, '$')
+	if start < 0 {
+		return Result{}, errors.New("nmea: missing '$'")
+	}
+	if prefix := strings.TrimSuffix(line[:start], ","); prefix != "" {
+		sess.DeviceID = prefix
+	}
+
+	fields, err := parseSentence(line[start+1:])
+	if err != nil {
+		return Result{}, err
+	}
+	if len(fields[0]) != 5 {
+		return Result{}, fmt.Errorf("nmea: invalid sentence type %q", fields[0])
+	}
+
+	switch fields[0][2:] { // strip talker ID (GP, GN, GL, ...)
+	case "RMC":
+		tp, ok, err := parseRMC(fields)
+		if err != nil || !ok {
+			return Result{}, err
+		}
+		return Result{Points: []service.TrackPoint{tp}}, nil
+	default:
+		return Result{}, nil
+	}
+}
+
+// parseSentence verifies the optional "*hh" checksum and splits the fields.
+func parseSentence(s string) ([]string, error) {
+	if body, sum, ok := strings.Cut(s, "*"); ok {
+		want, err := strconv.ParseUint(strings.TrimSpace(sum), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("nmea: invalid checksum %q", sum)
+		}
+		var got byte
+		for i := 0; i < len(body); i++ {
+			got ^= body[i]
+		}
+		if got != byte(want) {
+			return nil, fmt.Errorf("nmea: checksum mismatch: got %02X, want %02X", got, want)
+		}
+		s = body
+	}
+	return strings.Split(s, ","), nil
+}
+
+// parseRMC decodes
+// $GPRMC,hhmmss.ss,A,ddmm.mmmm,N,dddmm.mmmm,E,knots,course,ddmmyy,...
+// ok is false for sentences without a valid fix (status "V").
+func parseRMC(f []string) (tp service.TrackPoint, ok bool, err error) {
+	if len(f) < 10 {
+		return tp, false, errors.New("nmea: short RMC sentence")
+	}
+	if f[2] != "A" {
+		return tp, false, nil
+	}
+
+	if tp.Timestamp, err = parseDateTime(f[9], f[1]); err != nil {
+		return tp, false, err
+	}
+	if tp.Lat, err = parseCoord(f[3], f[4], 2); err != nil {
+		return tp, false, err
+	}
+	if tp.Lon, err = parseCoord(f[5], f[6], 3); err != nil {
+		return tp, false, err
+	}
+	if f[7] != "" {
+		knots, err := strconv.ParseFloat(f[7], 64)
+		if err != nil {
+			return tp, false, fmt.Errorf("nmea: invalid speed %q", f[7])
+		}
+		tp.Speed = knots * knotsToMetersPerSecond
+	}
+	if f[8] != "" {
+		course, err := strconv.ParseFloat(f[8], 64)
+		if err != nil {
+			return tp, false, fmt.Errorf("nmea: invalid course %q", f[8])
+		}
+		tp.Heading = &course
+	}
+	return tp, true, nil
+}
+
+// parseCoord converts "ddmm.mmmm" (degDigits=2) or "dddmm.mmmm" (3) plus a
+// hemisphere letter into signed decimal degrees.
+func parseCoord(v, hemi string, degDigits int) (float64, error) {
+	if len(v) < degDigits+2 {
+		return 0, fmt.Errorf("nmea: invalid coordinate %q", v)
+	}
+	deg, err := strconv.ParseFloat(v[:degDigits], 64)
+	if err != nil {
+		return 0, fmt.Errorf("nmea: invalid coordinate %q", v)
+	}
+	min, err := strconv.ParseFloat(v[degDigits:], 64)
+	if err != nil {
+		return 0, fmt.Errorf("nmea: invalid coordinate %q", v)
+	}
+	coord := deg + min/60
+	switch hemi {
+	case "N", "E":
+		return coord, nil
+	case "S", "W":
+		return -coord, nil
+	default:
+		return 0, fmt.Errorf("nmea: invalid hemisphere %q", hemi)
+	}
+}
+
+// parseDateTime combines an RMC "ddmmyy" date and "hhmmss.ss" time (UTC).
+func parseDateTime(date, clock string) (time.Time, error) {
+	if len(date) != 6 || len(clock) < 6 {
+		return time.Time{}, fmt.Errorf("nmea: invalid date/time %q %q", date, clock)
+	}
+	t, err := time.Parse("020106150405", date+clock[:6])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("nmea: invalid date/time %q %q", date, clock)
+	}
+	if len(clock) > 7 && clock[6] == '.' {
+		frac, err := strconv.ParseFloat("0"+clock[6:], 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("nmea: invalid time %q", clock)
+		}
+		t = t.Add(time.Duration(frac * float64(time.Second)))
+	}
+	return t, nil
+}
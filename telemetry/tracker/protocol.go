@@ -0,0 +1,96 @@
+// Package tracker ingests positions from legacy GPS trackers that speak raw
+// TCP/UDP protocols instead of HTTPS, and feeds them to the same Producer as
+// the HTTP handler.
+package tracker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+// Protocol decodes one tracker wire format.
+type Protocol interface {
+	// Name is used in logs and config (e.g. "nmea", "gt06").
+	Name() string
+	// Split frames the TCP byte stream into packets.
+	Split(data []byte, atEOF bool) (advance int, token []byte, err error)
+	// Decode parses one frame. Points are returned without a container ID
+	// resolution; the server maps Session.DeviceID to a container.
+	Decode(sess *Session, frame []byte) (Result, error)
+}
+
+// Result is the outcome of decoding a single frame.
+type Result struct {
+	Points []service.TrackPoint
+	// Reply is written back to the device when non-nil (e.g. a login ACK).
+	Reply []byte
+}
+
+// Session is per-connection state. Trackers usually identify themselves once
+// (login packet or ID prefix) and then only send positions.
+type Session struct {
+	DeviceID string
+}
+
+// Resolver maps a tracker's own ID (usually its IMEI) to a container ID.
+type Resolver func(deviceID string) (containerID string, ok bool)
+
+// StaticResolver resolves device IDs from a fixed table. With an empty table
+// the device ID is used as the container ID, for trackers provisioned with
+// the container number as their ID.
+func StaticResolver(devices map[string]string) Resolver {
+	return func(deviceID string) (string, bool) {
+		if len(devices) == 0 {
+			return deviceID, deviceID != ""
+		}
+		containerID, ok := devices[deviceID]
+		return containerID, ok
+	}
+}
+
+// ParseDeviceMap parses "imei=containerID,imei=containerID".
+func ParseDeviceMap(s string) (map[string]string, error) {
+	devices := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		deviceID, containerID, ok := strings.Cut(pair, "=")
+		if !ok || deviceID == "" || containerID == "" {
+			return nil, fmt.Errorf("invalid device mapping %q", pair)
+		}
+		devices[deviceID] = containerID
+	}
+	return devices, nil
+}
+
+// ProtocolByName returns the decoder for a config name.
+func ProtocolByName(name string) (Protocol, error) {
+	switch name {
+	case "nmea":
+		return NMEA{}, nil
+	case "gt06":
+		return GT06{}, nil
+	default:
+		return nil, fmt.Errorf("unknown tracker protocol %q", name)
+	}
+}
+
+// splitFrames applies a Protocol's Split to a whole UDP datagram.
+func splitFrames(p Protocol, data []byte) [][]byte {
+	var frames [][]byte
+	for len(data) > 0 {
+		advance, token, err := p.Split(data, true)
+		if err != nil || advance == 0 {
+			break
+		}
+		if token != nil {
+			frames = append(frames, token)
+		}
+		data = data[advance:]
+	}
+	return frames
+}
@@ -0,0 +1,233 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+const (
+	// Trackers send a heartbeat every few minutes; drop silent connections.
+	idleTimeout = 10 * time.Minute
+	// UDP sessions only hold the device ID learned from an earlier datagram.
+	udpSessionTTL = 30 * time.Minute
+	maxFrameSize  = 4096
+	// How long a frame's points are retried before they are dropped, and
+	// the backoff between retries of a failed write.
+	defaultIngestTimeout  = 30 * time.Second
+	ingestRetryBackoff    = 100 * time.Millisecond
+	maxIngestRetryBackoff = 2 * time.Second
+)
+
+// Server accepts tracker connections for one Protocol and publishes the
+// decoded points through the same Pipeline as the HTTP handler.
+type Server struct {
+	protocol      Protocol
+	pipeline      *service.Pipeline
+	resolve       Resolver
+	ingestTimeout time.Duration
+}
+
+// NewServer creates a server that maps device IDs with resolve.
+func NewServer(p Protocol, pipeline *service.Pipeline, resolve Resolver) *Server {
+	return &Server{protocol: p, pipeline: pipeline, resolve: resolve, ingestTimeout: defaultIngestTimeout}
+}
+
+// ServeTCP accepts connections until ctx is cancelled.
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	slog.Info("tracker TCP listener started", "protocol", s.protocol.Name(), "addr", ln.Addr().String())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	remote := conn.RemoteAddr().String()
+	sess := &Session{}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, maxFrameSize), maxFrameSize)
+	scanner.Split(s.protocol.Split)
+
+	conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	for scanner.Scan() {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		reply := s.handleFrame(ctx, sess, scanner.Bytes(), remote)
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				slog.Warn("tracker reply failed", "error", err, "remote", remote)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		slog.Info("tracker connection closed", "error", err, "remote", remote, "device_id", sess.DeviceID)
+	}
+}
+
+// ServeUDP reads datagrams until ctx is cancelled. Each datagram may carry
+// several frames; the device ID is remembered per remote address.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	slog.Info("tracker UDP listener started", "protocol", s.protocol.Name(), "addr", conn.LocalAddr().String())
+	sessions := make(map[string]*udpSession)
+	lastSweep := time.Now()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		now := time.Now()
+		remote := addr.String()
+		us, ok := sessions[remote]
+		if !ok {
+			us = &udpSession{}
+			sessions[remote] = us
+		}
+		us.lastSeen = now
+		if now.Sub(lastSweep) > udpSessionTTL {
+			for key, old := range sessions {
+				if now.Sub(old.lastSeen) > udpSessionTTL {
+					delete(sessions, key)
+				}
+			}
+			lastSweep = now
+		}
+
+		for _, frame := range splitFrames(s.protocol, buf[:n]) {
+			if reply := s.handleFrame(ctx, &us.Session, frame, remote); reply != nil {
+				if _, err := conn.WriteTo(reply, addr); err != nil {
+					slog.Warn("tracker reply failed", "error", err, "remote", remote)
+				}
+			}
+		}
+	}
+}
+
+type udpSession struct {
+	Session
+	lastSeen time.Time
+}
+
+// handleFrame decodes and publishes one frame, returning the reply to send.
+// Bad frames are logged and skipped; the connection stays open because
+// trackers rarely recover from being disconnected mid-buffer. Points that
+// could not be published, see publish, get no reply.
+func (s *Server) handleFrame(ctx context.Context, sess *Session, frame []byte, remote string) []byte {
+	res, err := s.protocol.Decode(sess, frame)
+	if err != nil {
+		slog.Warn("tracker frame rejected",
+			"error", err,
+			"protocol", s.protocol.Name(),
+			"remote", remote,
+			"device_id", sess.DeviceID,
+		)
+		return nil
+	}
+	if len(res.Points) == 0 {
+		return res.Reply
+	}
+
+	containerID, ok := s.resolve(sess.DeviceID)
+	if !ok {
+		slog.Warn("unknown tracker device",
+			"protocol", s.protocol.Name(),
+			"remote", remote,
+			"device_id", sess.DeviceID,
+		)
+		return res.Reply
+	}
+
+	points := make([]service.TrackPoint, 0, len(res.Points))
+	for _, tp := range res.Points {
+		tp.ContainerID = containerID
+		// Trackers resend their buffer after reconnecting; the fix time is
+		// unique per device, so the consumer's point_id key drops the replays.
+		if tp.PointID == "" {
+			tp.PointID = tp.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		points = append(points, tp)
+	}
+	result, err := s.publish(ctx, points)
+	if err != nil {
+		slog.Error("tracker points dropped",
+			"error", err,
+			"container_id", containerID,
+			"protocol", s.protocol.Name(),
+			"count", len(points),
+		)
+		return nil
+	}
+	for _, r := range result.Rejected {
+		slog.Warn("tracker point rejected", "error", r.Error, "container_id", containerID)
+	}
+	return res.Reply
+}
+
+// publish runs points through the pipeline, waiting out rate limits and
+// retrying failed writes for up to ingestTimeout. Trackers don't resend
+// what they were not acknowledged for: NMEA has no replies, and GT06 only
+// acknowledges logins, heartbeats and alarms. Retrying here is the only
+// second chance a fix gets, and blocking the connection meanwhile holds
+// back a buffer replay instead of dropping most of it.
+func (s *Server) publish(ctx context.Context, points []service.TrackPoint) (service.BatchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ingestTimeout)
+	defer cancel()
+
+	backoff := ingestRetryBackoff
+	for {
+		result, err := s.pipeline.Ingest(ctx, service.SourceTracker, points)
+		if err == nil {
+			return result, nil
+		}
+		wait := backoff
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			wait = limited.Wait
+		} else {
+			slog.Warn("tracker points not published, retrying", "error", err, "protocol", s.protocol.Name())
+			backoff = min(2*backoff, maxIngestRetryBackoff)
+		}
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(wait):
+		}
+	}
+}
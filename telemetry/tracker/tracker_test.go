@@ -0,0 +1,358 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lai/logistics/telemetry/service"
+)
+
+// mockProducer implements service.Producer for testing.
+type mockProducer struct {
+	mu      sync.Mutex
+	written []service.TrackPoint
+	err     error
+}
+
+func (m *mockProducer) Write(ctx context.Context, tp service.TrackPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, tp)
+	return nil
+}
+
+func (m *mockProducer) WriteBatch(ctx context.Context, points []service.TrackPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, points...)
+	return nil
+}
+
+func (m *mockProducer) Close() error {
+	return nil
+}
+
+func (m *mockProducer) points() []service.TrackPoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]service.TrackPoint(nil), m.written...)
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestNMEA_Decode(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantPoints int
+		wantErr    bool
+		wantDevice string
+	}{
+		{
+			name:       "RMC with device prefix",
+			line:       "861234567890123,$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A",
+			wantPoints: 1,
+			wantDevice: "861234567890123",
+		},
+		{
+			name:       "RMC without checksum",
+			line:       "$GNRMC,123519.50,A,4807.038,S,01131.000,W,0,0,230394,,",
+			wantPoints: 1,
+		},
+		{
+			name:    "checksum mismatch",
+			line:    "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*00",
+			wantErr: true,
+		},
+		{
+			name: "no fix",
+			line: "$GPRMC,123519,V,,,,,,,230394,,",
+		},
+		{
+			name: "GGA ignored",
+			line: "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		},
+		{
+			name:    "not NMEA",
+			line:    "hello",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := &Session{}
+			res, err := NMEA{}.Decode(sess, []byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(res.Points) != tt.wantPoints {
+				t.Fatalf("got %d points, want %d", len(res.Points), tt.wantPoints)
+			}
+			if tt.wantDevice != "" && sess.DeviceID != tt.wantDevice {
+				t.Errorf("got device %q, want %q", sess.DeviceID, tt.wantDevice)
+			}
+		})
+	}
+}
+
+func TestNMEA_DecodeRMCValues(t *testing.T) {
+	sess := &Session{DeviceID: "dev"}
+	res, err := NMEA{}.Decode(sess, []byte("$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := res.Points[0]
+
+	if want := time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC); !tp.Timestamp.Equal(want) {
+		t.Errorf("got timestamp %v, want %v", tp.Timestamp, want)
+	}
+	if want := 48 + 7.038/60; !almostEqual(tp.Lat, want) {
+		t.Errorf("got lat %v, want %v", tp.Lat, want)
+	}
+	if want := 11 + 31.0/60; !almostEqual(tp.Lon, want) {
+		t.Errorf("got lon %v, want %v", tp.Lon, want)
+	}
+	if want := 22.4 * knotsToMetersPerSecond; !almostEqual(tp.Speed, want) {
+		t.Errorf("got speed %v, want %v", tp.Speed, want)
+	}
+	if tp.Heading == nil || !almostEqual(*tp.Heading, 84.4) {
+		t.Errorf("got heading %v, want 84.4", tp.Heading)
+	}
+}
+
+// gt06Packet frames a GT06 packet with length, serial and CRC.
+func gt06Packet(kind byte, payload []byte, serial uint16) []byte {
+	body := []byte{byte(1 + len(payload) + 2 + 2), kind}
+	body = append(body, payload...)
+	body = binary.BigEndian.AppendUint16(body, serial)
+	pkt := append([]byte{0x78, 0x78}, body...)
+	pkt = binary.BigEndian.AppendUint16(pkt, crcITU(body))
+	return append(pkt, 0x0D, 0x0A)
+}
+
+func gt06LocationPayload(lat, lon float64, speedKmh byte, north, east bool) []byte {
+	p := []byte{26, 1, 23, 10, 0, 0, 0xC9} // 2026-01-23 10:00:00, 9 satellites
+	p = binary.BigEndian.AppendUint32(p, uint32(math.Round(lat*60*30000)))
+	p = binary.BigEndian.AppendUint32(p, uint32(math.Round(lon*60*30000)))
+	p = append(p, speedKmh)
+	status := uint16(0x1000) | 90 // positioned, course 90
+	if north {
+		status |= 0x0400
+	}
+	if !east {
+		status |= 0x0800
+	}
+	p = binary.BigEndian.AppendUint16(p, status)
+	return append(p, make([]byte, 8)...) // LBS block
+}
+
+func TestGT06_LoginAndLocation(t *testing.T) {
+	sess := &Session{}
+	login := gt06Packet(gt06Login, []byte{0x08, 0x61, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23}, 1)
+
+	res, err := GT06{}.Decode(sess, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.DeviceID != "861234567890123" {
+		t.Errorf("got device %q, want %q", sess.DeviceID, "861234567890123")
+	}
+	if want := gt06Packet(gt06Login, nil, 1); !bytes.Equal(res.Reply, want) {
+		t.Errorf("got reply % X, want % X", res.Reply, want)
+	}
+
+	loc := gt06Packet(gt06Location, gt06LocationPayload(51.9225, 4.47917, 36, true, true), 2)
+	res, err = GT06{}.Decode(sess, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Points) != 1 {
+		t.Fatalf("got %d points, want 1", len(res.Points))
+	}
+	tp := res.Points[0]
+	if !almostEqual(tp.Lat, 51.9225) || !almostEqual(tp.Lon, 4.47917) {
+		t.Errorf("got %v,%v, want 51.9225,4.47917", tp.Lat, tp.Lon)
+	}
+	if !almostEqual(tp.Speed, 10) {
+		t.Errorf("got speed %v, want 10", tp.Speed)
+	}
+	if tp.Heading == nil || *tp.Heading != 90 {
+		t.Errorf("got heading %v, want 90", tp.Heading)
+	}
+
+	// Southern/western hemisphere flags
+	loc = gt06Packet(gt06Location, gt06LocationPayload(33.86, 151.2, 0, false, false), 3)
+	res, _ = GT06{}.Decode(sess, loc)
+	if tp := res.Points[0]; !almostEqual(tp.Lat, -33.86) || !almostEqual(tp.Lon, -151.2) {
+		t.Errorf("got %v,%v, want -33.86,-151.2", tp.Lat, tp.Lon)
+	}
+
+	// Corrupted CRC
+	loc[len(loc)-3] ^= 0xFF
+	if _, err := (GT06{}).Decode(sess, loc); err == nil {
+		t.Error("expected crc error")
+	}
+}
+
+func TestGT06_Split(t *testing.T) {
+	a := gt06Packet(gt06Heartbeat, []byte{0x40, 0x04, 0x04, 0x00, 0x01}, 7)
+	b := gt06Packet(gt06Login, []byte{0x08, 0x61, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23}, 8)
+	stream := append(append([]byte{0x00, 0x11}, a...), b...) // leading noise
+
+	frames := splitFrames(GT06{}, stream)
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if !bytes.Equal(frames[0], a) || !bytes.Equal(frames[1], b) {
+		t.Errorf("frames don't match input packets")
+	}
+}
+
+func TestServer_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockProducer{}
+	srv := NewServer(NMEA{}, service.NewPipeline(mock), StaticResolver(map[string]string{"861234567890123": "MSKU1234567"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.ServeTCP(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first sentence carries the device ID
+	conn.Write([]byte("861234567890123,$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n" +
+		"$GPRMC,123520,A,4807.040,N,01131.002,E,022.4,084.4,230394,003.1,W\r\n"))
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(mock.points()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatalf("ServeTCP returned %v", err)
+	}
+
+	points := mock.points()
+	if len(points) != 2 {
+		t.Fatalf("got %d written points, want 2", len(points))
+	}
+	for _, tp := range points {
+		if tp.ContainerID != "MSKU1234567" {
+			t.Errorf("got ContainerID %q, want %q", tp.ContainerID, "MSKU1234567")
+		}
+		if tp.PointID == "" {
+			t.Error("expected PointID derived from fix time")
+		}
+	}
+}
+
+func TestServer_HandleFrame(t *testing.T) {
+	login := gt06Packet(gt06Login, []byte{0x08, 0x61, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23}, 1)
+	// Alarms are the position packets GT06 acknowledges
+	alarm := gt06Packet(gt06Alarm, gt06LocationPayload(51.9225, 4.47917, 36, true, true), 2)
+
+	tests := []struct {
+		name        string
+		containerID string
+		mode        service.ContainerIDMode
+		err         error
+		wantReply   bool
+		wantWritten int
+	}{
+		{"published", "MSKU1234565", service.ContainerIDStrict, nil, true, 1},
+		// Retrying won't help, so the point is acknowledged and dropped
+		{"invalid container ID", "MSKU1234567", service.ContainerIDStrict, nil, true, 0},
+		{"lenient container ID", "MSKU1234567", service.ContainerIDLenient, nil, true, 1},
+		// Not acknowledged once the retries ran out
+		{"kafka down", "MSKU1234565", service.ContainerIDStrict, errors.New("kafka unavailable"), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockProducer{err: tt.err}
+			srv := NewServer(GT06{}, service.NewPipeline(mock, service.WithContainerIDMode(tt.mode)),
+				StaticResolver(map[string]string{"861234567890123": tt.containerID}))
+			srv.ingestTimeout = 50 * time.Millisecond
+			sess := &Session{}
+			ctx := context.Background()
+
+			if reply := srv.handleFrame(ctx, sess, login, "test"); reply == nil {
+				t.Fatal("login not acknowledged")
+			}
+			reply := srv.handleFrame(ctx, sess, alarm, "test")
+			if got := reply != nil; got != tt.wantReply {
+				t.Errorf("got reply %v, want %v", got, tt.wantReply)
+			}
+			if got := len(mock.points()); got != tt.wantWritten {
+				t.Errorf("got %d written points, want %d", got, tt.wantWritten)
+			}
+		})
+	}
+}
+
+func TestServer_ReplayBeyondRateLimit(t *testing.T) {
+	mock := &mockProducer{}
+	limiter := service.NewRateLimiter(service.RateLimitConfig{DeviceRate: 100, DeviceBurst: 2})
+	srv := NewServer(GT06{}, service.NewPipeline(mock, service.WithRateLimit(limiter)),
+		StaticResolver(map[string]string{"861234567890123": "MSKU1234565"}))
+	sess := &Session{}
+	ctx := context.Background()
+
+	login := gt06Packet(gt06Login, []byte{0x08, 0x61, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23}, 1)
+	srv.handleFrame(ctx, sess, login, "test")
+	// A buffer replay: location packets get no reply, so each one must be
+	// published while it is handled
+	const frames = 15
+	for i := range frames {
+		loc := gt06Packet(gt06Location, gt06LocationPayload(51.9225, 4.47917, 36, true, true), uint16(2+i))
+		srv.handleFrame(ctx, sess, loc, "test")
+	}
+
+	if got := len(mock.points()); got != frames {
+		t.Errorf("got %d published points, want %d", got, frames)
+	}
+}
+
+func TestServer_RetriesFailedWrite(t *testing.T) {
+	mock := &mockProducer{err: errors.New("kafka unavailable")}
+	srv := NewServer(GT06{}, service.NewPipeline(mock),
+		StaticResolver(map[string]string{"861234567890123": "MSKU1234565"}))
+	sess := &Session{}
+	ctx := context.Background()
+
+	login := gt06Packet(gt06Login, []byte{0x08, 0x61, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23}, 1)
+	srv.handleFrame(ctx, sess, login, "test")
+	time.AfterFunc(150*time.Millisecond, func() {
+		mock.mu.Lock()
+		mock.err = nil
+		mock.mu.Unlock()
+	})
+	loc := gt06Packet(gt06Location, gt06LocationPayload(51.9225, 4.47917, 36, true, true), 2)
+	srv.handleFrame(ctx, sess, loc, "test")
+
+	if got := len(mock.points()); got != 1 {
+		t.Errorf("got %d published points, want 1", got)
+	}
+}